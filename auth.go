@@ -0,0 +1,255 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// api key locations of WithAPIKey
+const (
+	APIKeyInHeader = "header"
+	APIKeyInQuery  = "query"
+)
+
+// tokenExpiryDelta a token is refreshed this long before it expires
+const tokenExpiryDelta = 10 * time.Second
+
+// tokenFetchTimeout bound of a shared fetch of TokenCache, its waiters give up after it
+const tokenFetchTimeout = 30 * time.Second
+
+// Token access token returned by a TokenSource
+type Token struct {
+	AccessToken string
+	// TokenType type of the token, Bearer if empty
+	TokenType string
+	// Expiry expiration time of the token, zero means the token never expires
+	Expiry time.Time
+}
+
+// Valid whether the token is set and not about to expire
+func (t *Token) Valid() bool {
+	return t.valid(tokenExpiryDelta)
+}
+
+// valid whether the token is set and does not expire within margin
+func (t *Token) valid(margin time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(margin).Before(t.Expiry))
+}
+
+// expiryMargin time before the expiry of a fetched token it is refreshed, at most a quarter of its lifetime
+// so a token which lives less than tokenExpiryDelta is still reused
+func expiryMargin(t *Token) time.Duration {
+	return max(min(tokenExpiryDelta, time.Until(t.Expiry)/4), 0)
+}
+
+// header value of the Authorization header
+func (t *Token) header() string {
+	tokenType := t.TokenType
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + t.AccessToken
+}
+
+// TokenSource source of access tokens, must be safe for concurrent use
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc adapter to use a function as TokenSource
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token implements TokenSource
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// tokenInvalidator implemented by token sources which cache tokens
+type tokenInvalidator interface {
+	Invalidate(accessToken string)
+}
+
+// TokenCache cache the token of a source until shortly before it expires
+// concurrent callers share a single fetch, create it once and share it across requests
+type TokenCache struct {
+	src TokenSource
+
+	mu     sync.Mutex
+	token  *Token
+	margin time.Duration
+	call   *tokenCall
+}
+
+// tokenCall a fetch in flight
+type tokenCall struct {
+	done    chan struct{}
+	token   *Token
+	err     error
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// NewTokenCache new token cache of src
+func NewTokenCache(src TokenSource) *TokenCache {
+	return &TokenCache{src: src}
+}
+
+// Token return the cached token, or fetch a new one if it is missing or about to expire
+func (c *TokenCache) Token(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+	if c.token.valid(c.margin) {
+		token := c.token
+		c.mu.Unlock()
+		return token, nil
+	}
+	call := c.call
+	if call == nil {
+		// the fetch is shared, it must not be canceled by the first caller, but a hanging source must not block the refreshes forever
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+		call = &tokenCall{done: make(chan struct{}), ctx: ctx, cancel: cancel}
+		c.call = call
+		go c.fetch(call)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		c.leave(call)
+		return nil, ctx.Err()
+	case <-call.ctx.Done():
+		// the fetch is over, or the source ignores its deadline
+		select {
+		case <-call.done:
+			return call.token, call.err
+		default:
+		}
+		c.leave(call)
+		return nil, errors.WithMessage(call.ctx.Err(), "ray.token.fetch")
+	}
+}
+
+// leave a waiter gave up, the call is dropped once nobody waits for it, the next caller starts a new fetch
+func (c *TokenCache) leave(call *tokenCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		if c.call == call {
+			c.call = nil
+		}
+	}
+}
+
+// fetch fetch a token from the source and publish it to the waiters of call
+func (c *TokenCache) fetch(call *tokenCall) {
+	defer call.cancel()
+	token, err := c.src.Token(call.ctx)
+	if err == nil && (token == nil || token.AccessToken == "") {
+		err = errors.New("ray.token.fetch, empty access token")
+	}
+	call.token, call.err = token, err
+
+	c.mu.Lock()
+	if err == nil {
+		c.token = token
+		c.margin = expiryMargin(token)
+	}
+	if c.call == call {
+		c.call = nil
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// Invalidate drop the cached token if it is still accessToken, eg. after the server rejected it with 401
+func (c *TokenCache) Invalidate(accessToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != nil && c.token.AccessToken == accessToken {
+		c.token = nil
+	}
+}
+
+// authorize set the Authorization header from the token source of options
+func authorize(req *http.Request, opts *Options) error {
+	if opts.TokenSource == nil {
+		return nil
+	}
+	token, err := opts.TokenSource.Token(req.Context())
+	if err != nil {
+		return errors.WithMessage(err, "token")
+	}
+	req.Header.Set("Authorization", token.header())
+	return nil
+}
+
+// invalidateToken invalidate the token rejected by the server, report whether the request should be retried with a new token
+// the retry happens once per request
+func invalidateToken(req *http.Request, opts *Options) bool {
+	if opts.TokenSource == nil || opts.tokenRefreshed {
+		return false
+	}
+	opts.tokenRefreshed = true
+	// a source which does not cache fetches a new token anyway
+	if ts, ok := opts.TokenSource.(tokenInvalidator); ok {
+		if _, accessToken, found := strings.Cut(req.Header.Get("Authorization"), " "); found {
+			ts.Invalidate(accessToken)
+		}
+	}
+	return true
+}
+
+// WithBasicAuth set Authorization header with basic auth
+func WithBasicAuth(user string, password string) OptionHandle {
+	return WithRequestHook(func(req *http.Request) error {
+		req.SetBasicAuth(user, password)
+		return nil
+	})
+}
+
+// WithBearerToken set Authorization header with a static bearer token
+func WithBearerToken(token string) OptionHandle {
+	return WithRequestHook(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// WithAPIKey set api key in the header or the query, in is APIKeyInHeader or APIKeyInQuery
+func WithAPIKey(in string, name string, value string) OptionHandle {
+	return WithRequestHook(func(req *http.Request) error {
+		switch in {
+		case APIKeyInHeader:
+			req.Header.Set(name, value)
+		case APIKeyInQuery:
+			q := req.URL.Query()
+			q.Set(name, value)
+			req.URL.RawQuery = q.Encode()
+		default:
+			return errors.Errorf("ray.auth.apikey, invalid location,[in]%s", in)
+		}
+		return nil
+	})
+}
+
+// WithTokenSource set Authorization header with tokens of ts, a source which does not cache tokens like TokenCache is wrapped in one,
+// the token rejected with 401 is invalidated and the request is retried once with a new token
+// create the option once and share it, each call of WithTokenSource caches on its own
+func WithTokenSource(ts TokenSource) OptionHandle {
+	if _, ok := ts.(tokenInvalidator); !ok && ts != nil {
+		ts = NewTokenCache(ts)
+	}
+	return func(opt *Options) {
+		opt.TokenSource = ts
+	}
+}
@@ -0,0 +1,260 @@
+package ray
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newAuthTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-Api-Key") + "|" + r.URL.RawQuery))
+	}))
+}
+
+func TestAuthHelpers(t *testing.T) {
+	server := newAuthTestServer()
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		opt  OptionHandle
+		want string
+	}{
+		{"basic", WithBasicAuth("user", "secret"), "Basic dXNlcjpzZWNyZXQ=||a=1"},
+		{"bearer", WithBearerToken("tok"), "Bearer tok||a=1"},
+		{"apikey header", WithAPIKey(APIKeyInHeader, "X-Api-Key", "key"), "|key|a=1"},
+		{"apikey query", WithAPIKey(APIKeyInQuery, "api_key", "key"), "||a=1&api_key=key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := Do(NewOptions(WithURL(server.URL), WithQuery("a=1"), tt.opt))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if string(response) != tt.want {
+				t.Errorf("Unexpected response body. Expected: %s, Got: %s", tt.want, string(response))
+			}
+		})
+	}
+}
+
+func TestTokenCacheConcurrent(t *testing.T) {
+	server := newAuthTestServer()
+	defer server.Close()
+
+	var fetches int32
+	cache := NewTokenCache(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &Token{AccessToken: "tok", Expiry: time.Now().Add(time.Hour)}, nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := Do(NewOptions(WithURL(server.URL), WithTokenSource(cache)))
+			if err != nil || string(response) != "Bearer tok||" {
+				t.Errorf("Unexpected response: %s, %v", response, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Unexpected token fetches. Expected: %d, Got: %d", 1, n)
+	}
+}
+
+func TestTokenCacheShortLived(t *testing.T) {
+	var fetches int32
+	cache := NewTokenCache(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		atomic.AddInt32(&fetches, 1)
+		return &Token{AccessToken: "tok", Expiry: time.Now().Add(200 * time.Millisecond)}, nil
+	}))
+
+	// a token living less than tokenExpiryDelta is reused
+	for i := 0; i < 5; i++ {
+		if _, err := cache.Token(context.Background()); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Unexpected fetches: %d", n)
+	}
+	// and refreshed within the last quarter of its lifetime
+	time.Sleep(160 * time.Millisecond)
+	if _, err := cache.Token(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Unexpected fetches: %d", n)
+	}
+}
+
+func TestTokenCacheHangingFetch(t *testing.T) {
+	var fetches int32
+	hang := make(chan struct{})
+	defer close(hang)
+	cache := NewTokenCache(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		// the first fetch hangs and ignores its context
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			<-hang
+		}
+		return &Token{AccessToken: "tok", Expiry: time.Now().Add(time.Hour)}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// nobody waits for the hanging fetch anymore, the next caller fetches again
+	token, err := cache.Token(context.Background())
+	if err != nil || token.AccessToken != "tok" || atomic.LoadInt32(&fetches) != 2 {
+		t.Errorf("Unexpected token: %+v, %v, fetches %d", token, err, atomic.LoadInt32(&fetches))
+	}
+}
+
+func TestTokenRefreshOnUnauthorized(t *testing.T) {
+	var calls int32
+	// Create a test server, only the second token is accepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var fetches int32
+	cache := NewTokenCache(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return &Token{AccessToken: fmt.Sprintf("tok-%d", n)}, nil
+	}))
+
+	// Call the function being tested
+	response, err := Do(NewOptions(WithURL(server.URL), WithTokenSource(cache)))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "ok" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "ok", string(response))
+	}
+	if calls != 2 || fetches != 2 {
+		t.Errorf("Unexpected calls %d and fetches %d", calls, fetches)
+	}
+
+	// a rejected token is only refreshed once per request
+	cache.Invalidate("tok-2")
+	_, err = Do(NewOptions(WithURL(server.URL), WithTokenSource(cache)))
+	if err == nil {
+		t.Errorf("Expected unauthorized error, got nil")
+	}
+	if calls != 4 || fetches != 4 {
+		t.Errorf("Unexpected calls %d and fetches %d", calls, fetches)
+	}
+}
+
+func TestTokenSourceCached(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// a plain source is cached and refreshed after a 401
+	var fetches int32
+	option := WithTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return &Token{AccessToken: fmt.Sprintf("tok-%d", n)}, nil
+	}))
+	for i := 0; i < 3; i++ {
+		_, err := Do(NewOptions(WithURL(server.URL), option))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Unexpected fetches: %d", fetches)
+	}
+}
+
+func TestTokenRefreshRetries(t *testing.T) {
+	var calls int32
+	// Create a test server, a token is only accepted after a refresh unless reject is set
+	var reject atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if reject.Load() || r.Header.Get("Authorization") == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var fetches int32
+	newOption := func() OptionHandle {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&fetches, 0)
+		return WithTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+			n := atomic.AddInt32(&fetches, 1)
+			return &Token{AccessToken: fmt.Sprintf("tok-%d", n)}, nil
+		}))
+	}
+
+	// a refreshed token which is rejected again is not retried by DoRetry
+	reject.Store(true)
+	_, err := DoRetry(NewOptions(WithURL(server.URL), newOption()))
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if calls != 2 || fetches != 2 {
+		t.Errorf("Unexpected calls %d and fetches %d", calls, fetches)
+	}
+
+	// nor by DoStream
+	err = DoStream(NewOptions(WithURL(server.URL), newOption()), func(r *bufio.Reader) error { return nil })
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if calls != 2 || fetches != 2 {
+		t.Errorf("Unexpected calls %d and fetches %d", calls, fetches)
+	}
+
+	// DoStream refreshes the token once whatever the retry times
+	reject.Store(false)
+	var body []byte
+	err = DoStream(NewOptions(WithURL(server.URL), WithRetryTimes(0), newOption()), func(r *bufio.Reader) error {
+		body, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Unexpected response: %s, %v", body, err)
+	}
+	if calls != 2 || fetches != 2 {
+		t.Errorf("Unexpected calls %d and fetches %d", calls, fetches)
+	}
+}
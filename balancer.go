@@ -0,0 +1,336 @@
+package ray
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaults of Balancer
+const (
+	defaultBalancerMaxFailures   = 5
+	defaultBalancerEjectDuration = 30 * time.Second
+)
+
+// ErrNoEndpoint the balancer has no endpoint to send the request to
+var ErrNoEndpoint = errors.New("ray.balancer.no.endpoint")
+
+// BalanceStrategy how the balancer picks an endpoint
+type BalanceStrategy int
+
+const (
+	// RoundRobin endpoints in turn
+	RoundRobin BalanceStrategy = iota
+	// Random endpoint at random
+	Random
+	// LeastInFlight endpoint with the fewest in-flight requests, ties in turn
+	LeastInFlight
+	// Weighted smooth weighted round robin by Endpoint.Weight
+	Weighted
+)
+
+// String name of the strategy
+func (s BalanceStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round_robin"
+	case Random:
+		return "random"
+	case LeastInFlight:
+		return "least_in_flight"
+	case Weighted:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+// Endpoint replica of the upstream
+type Endpoint struct {
+	// URL base url, eg. http://10.0.0.1:8080/api, it replaces the scheme and host of the request url and prefixes its path
+	URL string
+	// Weight weight of the Weighted strategy, 1 if 0
+	Weight int
+}
+
+// EndpointStats state of an endpoint
+type EndpointStats struct {
+	InFlight int
+	// Failures consecutive failures
+	Failures int
+	// Ejected whether the endpoint is ejected
+	Ejected bool
+}
+
+// Balancer spread the requests over several endpoints of one upstream, the request url is a path or any url whose scheme and host are replaced,
+// an endpoint failing MaxFailures times in a row is ejected for EjectDuration, once back a single failure ejects it again,
+// retries and hedged attempts of a call go to an endpoint it has not tried yet while there is one,
+// create it once per upstream and share it with WithBalancer
+type Balancer struct {
+	// Endpoints static endpoints, used when Resolver is nil
+	Endpoints []Endpoint
+	// Resolver resolve the endpoints on each pick, eg. from service discovery, it should cache them
+	Resolver func(ctx context.Context) ([]Endpoint, error)
+	// Strategy how an endpoint is picked, RoundRobin by default
+	Strategy BalanceStrategy
+	// MaxFailures consecutive failures before the endpoint is ejected, 5 if 0
+	MaxFailures int
+	// EjectDuration time an ejected endpoint gets no requests, 30s if 0
+	EjectDuration time.Duration
+	// IsFailure classify the result of a request, transport errors and 5xx responses by default
+	IsFailure func(resp *http.Response, err error) bool
+	// Now clock, time.Now if nil
+	Now func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointState
+	next      int
+	rand      *rand.Rand
+}
+
+// endpointState state of an endpoint
+type endpointState struct {
+	inFlight      int
+	failures      int
+	ejectedUntil  time.Time
+	currentWeight int
+}
+
+// balancerTriedKey context key of the endpoints tried by a call
+type balancerTriedKey struct{}
+
+// balancerTried endpoints tried by a call, shared by its attempts
+type balancerTried struct {
+	mu   sync.Mutex
+	urls map[string]bool
+}
+
+// Stats state of the known endpoints by url
+func (b *Balancer) Stats() map[string]EndpointStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	stats := make(map[string]EndpointStats, len(b.endpoints))
+	for u, s := range b.endpoints {
+		stats[u] = EndpointStats{InFlight: s.inFlight, Failures: s.failures, Ejected: now.Before(s.ejectedUntil)}
+	}
+	return stats
+}
+
+// track return a context tracking the endpoints tried by the attempts of a call, a nil balancer returns ctx
+func (b *Balancer) track(ctx context.Context) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, balancerTriedKey{}, &balancerTried{urls: map[string]bool{}})
+}
+
+// pick pick an endpoint and rewrite the url of opts, report records the result of the request and release ends it,
+// a nil balancer returns no-op funcs
+func (b *Balancer) pick(opts *Options) (report func(resp *http.Response, err error), release func(), err error) {
+	if b == nil {
+		return func(*http.Response, error) {}, func() {}, nil
+	}
+	endpoints := b.Endpoints
+	if b.Resolver != nil {
+		endpoints, err = b.Resolver(opts.context())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, nil, ErrNoEndpoint
+	}
+	tried, _ := opts.context().Value(balancerTriedKey{}).(*balancerTried)
+
+	b.mu.Lock()
+	endpoint := b.choose(endpoints, tried)
+	state := b.endpoints[endpoint.URL]
+	state.inFlight++
+	b.mu.Unlock()
+
+	if tried != nil {
+		tried.mu.Lock()
+		tried.urls[endpoint.URL] = true
+		tried.mu.Unlock()
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			b.mu.Lock()
+			state.inFlight--
+			b.mu.Unlock()
+		})
+	}
+	reqUrl, err := endpointURL(endpoint.URL, opts.URL)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	opts.URL = reqUrl
+	return func(resp *http.Response, err error) { b.report(state, resp, err) }, release, nil
+}
+
+// choose choose an endpoint, the ejected and tried ones are skipped while others are left, the caller holds b.mu
+func (b *Balancer) choose(endpoints []Endpoint, tried *balancerTried) Endpoint {
+	if b.endpoints == nil {
+		b.endpoints = map[string]*endpointState{}
+	}
+	known := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		known[e.URL] = true
+		if _, ok := b.endpoints[e.URL]; !ok {
+			b.endpoints[e.URL] = &endpointState{}
+		}
+	}
+	// forget the endpoints gone from the resolver
+	for u, s := range b.endpoints {
+		if !known[u] && s.inFlight == 0 {
+			delete(b.endpoints, u)
+		}
+	}
+	now := b.now()
+	healthy := make([]Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if !now.Before(b.endpoints[e.URL].ejectedUntil) {
+			healthy = append(healthy, e)
+		}
+	}
+	// all ejected, better try one than fail every request
+	if len(healthy) == 0 {
+		healthy = endpoints
+	}
+	candidates := healthy
+	if tried != nil {
+		tried.mu.Lock()
+		untried := make([]Endpoint, 0, len(healthy))
+		for _, e := range healthy {
+			if !tried.urls[e.URL] {
+				untried = append(untried, e)
+			}
+		}
+		tried.mu.Unlock()
+		if len(untried) > 0 {
+			candidates = untried
+		}
+	}
+
+	switch b.Strategy {
+	case Random:
+		if b.rand == nil {
+			b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		return candidates[b.rand.Intn(len(candidates))]
+	case LeastInFlight:
+		start := b.next % len(candidates)
+		b.next++
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			e := candidates[(start+i)%len(candidates)]
+			if b.endpoints[e.URL].inFlight < b.endpoints[best.URL].inFlight {
+				best = e
+			}
+		}
+		return best
+	case Weighted:
+		total := 0
+		var best Endpoint
+		var bestState *endpointState
+		for _, e := range candidates {
+			weight := e.Weight
+			if weight <= 0 {
+				weight = 1
+			}
+			s := b.endpoints[e.URL]
+			s.currentWeight += weight
+			total += weight
+			if bestState == nil || s.currentWeight > bestState.currentWeight {
+				best, bestState = e, s
+			}
+		}
+		bestState.currentWeight -= total
+		return best
+	}
+	endpoint := candidates[b.next%len(candidates)]
+	b.next++
+	return endpoint
+}
+
+// report record the result of a request to the endpoint, a canceled request neither succeeds nor fails
+func (b *Balancer) report(state *endpointState, resp *http.Response, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.isFailure(resp, err) {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	now := b.now()
+	if state.failures >= b.maxFailures() && !now.Before(state.ejectedUntil) {
+		eject := b.EjectDuration
+		if eject <= 0 {
+			eject = defaultBalancerEjectDuration
+		}
+		state.ejectedUntil = now.Add(eject)
+		// back from the ejection a single failure ejects it again
+		state.failures = b.maxFailures() - 1
+	}
+}
+
+func (b *Balancer) isFailure(resp *http.Response, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(resp, err)
+	}
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (b *Balancer) maxFailures() int {
+	if b.MaxFailures > 0 {
+		return b.MaxFailures
+	}
+	return defaultBalancerMaxFailures
+}
+
+func (b *Balancer) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// endpointURL url of the request sent to the endpoint
+func endpointURL(endpoint string, reqUrl string) (string, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(reqUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	if base.User != nil {
+		u.User = base.User
+	}
+	if base.Path != "" && base.Path != "/" {
+		u.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+		u.RawPath = ""
+	}
+	return u.String(), nil
+}
+
+// WithBalancer set balancer of the endpoints of the upstream
+func WithBalancer(b *Balancer) OptionHandle {
+	return func(opt *Options) {
+		opt.Balancer = b
+	}
+}
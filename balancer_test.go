@@ -0,0 +1,171 @@
+package ray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+func TestBalancer(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").Reply(http.StatusOK, "ok")
+	mock.On(http.MethodGet, "/api/item").Reply(http.StatusOK, "ok")
+	balancer := &Balancer{Endpoints: []Endpoint{{URL: "http://a.test"}, {URL: "http://b.test/api/"}, {URL: "http://c.test"}}}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithBalancer(balancer)}})
+
+	for i := 0; i < 6; i++ {
+		_, err := client.Do(client.NewOptions(WithURL("/item"), WithQuery("id=1")))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	var urls []string
+	for _, call := range mock.Calls() {
+		urls = append(urls, call.URL.String())
+	}
+	expected := []string{"http://a.test/item?id=1", "http://b.test/api/item?id=1", "http://c.test/item?id=1"}
+	for i, u := range urls {
+		if u != expected[i%3] {
+			t.Errorf("Unexpected url of call %d: %s", i, u)
+		}
+	}
+	for u, stats := range balancer.Stats() {
+		if stats.InFlight != 0 {
+			t.Errorf("Unexpected in-flight requests of %s: %d", u, stats.InFlight)
+		}
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").Match(func(req *http.Request, body []byte) bool { return req.URL.Host == "a.test" }).Reply(http.StatusInternalServerError, "fail")
+	mock.On(http.MethodGet, "/item").Reply(http.StatusOK, "ok")
+	balancer := &Balancer{Endpoints: []Endpoint{{URL: "http://a.test"}, {URL: "http://b.test"}}, MaxFailures: 2, EjectDuration: time.Minute, Now: clock.Now}
+	client := NewClient(Config{RetryTimes: 2, Options: []OptionHandle{WithTransport(mock), WithBalancer(balancer), WithLogger(func(opt *Options, err error) error { return nil })}})
+
+	// the retry goes to the other endpoint
+	for i := 0; i < 2; i++ {
+		body, err := client.DoRetry(client.NewOptions(WithURL("/item")))
+		if err != nil || string(body) != "ok" {
+			t.Errorf("Unexpected response: %v %s", err, string(body))
+		}
+	}
+	calls := mock.Calls()
+	if len(calls) != 4 || calls[0].URL.Host != "a.test" || calls[1].URL.Host != "b.test" || calls[2].URL.Host != "a.test" || calls[3].URL.Host != "b.test" {
+		t.Errorf("Unexpected calls: %d", len(calls))
+	}
+
+	// a is ejected after MaxFailures failures
+	if stats := balancer.Stats()["http://a.test"]; !stats.Ejected {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	mock.Reset()
+	for i := 0; i < 4; i++ {
+		client.DoRetry(client.NewOptions(WithURL("/item")))
+	}
+	if calls := mock.Calls(); len(calls) != 4 || calls[0].URL.Host != "b.test" || calls[3].URL.Host != "b.test" {
+		t.Errorf("Unexpected calls: %d", len(calls))
+	}
+
+	// back from the ejection a single failure ejects it again
+	clock.Add(time.Minute)
+	mock.Reset()
+	for i := 0; i < 4; i++ {
+		client.DoRetry(client.NewOptions(WithURL("/item")))
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/item", 5)
+	if stats := balancer.Stats()["http://a.test"]; !stats.Ejected {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestBalancerStrategy(t *testing.T) {
+	pick := func(b *Balancer, n int) map[string]int {
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			opts := NewOptions(WithURL("/item"))
+			_, release, err := b.pick(&opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			release()
+			counts[opts.URL]++
+		}
+		return counts
+	}
+
+	weighted := &Balancer{Strategy: Weighted, Endpoints: []Endpoint{{URL: "http://a.test", Weight: 3}, {URL: "http://b.test"}}}
+	if counts := pick(weighted, 8); counts["http://a.test/item"] != 6 || counts["http://b.test/item"] != 2 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+
+	random := &Balancer{Strategy: Random, Endpoints: []Endpoint{{URL: "http://a.test"}, {URL: "http://b.test"}}}
+	if counts := pick(random, 100); counts["http://a.test/item"]+counts["http://b.test/item"] != 100 || counts["http://a.test/item"] == 0 || counts["http://b.test/item"] == 0 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+
+	// the busy endpoint is skipped
+	least := &Balancer{Strategy: LeastInFlight, Endpoints: []Endpoint{{URL: "http://a.test"}, {URL: "http://b.test"}}}
+	opts := NewOptions(WithURL("/item"))
+	least.pick(&opts)
+	if counts := pick(least, 3); counts["http://b.test/item"] != 3 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+
+	// a resolver without endpoints fails the request
+	empty := &Balancer{Resolver: func(ctx context.Context) ([]Endpoint, error) { return nil, nil }}
+	_, err := Do(NewOptions(WithURL("/item"), WithBalancer(empty)))
+	if !errors.Is(err, ErrNoEndpoint) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestEndpointURL(t *testing.T) {
+	for _, c := range []struct {
+		endpoint string
+		url      string
+		expected string
+	}{
+		{"http://a.test", "/item?id=1", "http://a.test/item?id=1"},
+		{"https://a.test:8443/api", "item", "https://a.test:8443/api/item"},
+		{"http://a.test/api/", "http://www.example.com/item#top", "http://a.test/api/item#top"},
+	} {
+		u, err := endpointURL(c.endpoint, c.url)
+		if err != nil || u != c.expected {
+			t.Errorf("Unexpected url of %s %s: %v %s", c.endpoint, c.url, err, u)
+		}
+	}
+}
+
+func TestBalancerTokenRefresh(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/api/users").Header("Authorization", "Bearer tok-2").Reply(http.StatusOK, "ok")
+	mock.On(http.MethodGet, "/api/users").Reply(http.StatusUnauthorized, "")
+	mock.On(http.MethodGet, "/api/api/users").Reply(http.StatusOK, "ok")
+	fetches := 0
+	tokens := NewTokenCache(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		fetches++
+		return &Token{AccessToken: fmt.Sprintf("tok-%d", fetches)}, nil
+	}))
+	balancer := &Balancer{Endpoints: []Endpoint{{URL: "http://a.test/api"}}}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithBalancer(balancer), WithTokenSource(tokens)}})
+
+	// the retry after the 401 is sent to the base path of the endpoint once
+	body, err := client.Do(client.NewOptions(WithURL("/users")))
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	var paths []string
+	for _, call := range mock.Calls() {
+		paths = append(paths, call.URL.Path)
+	}
+	if len(paths) != 2 || paths[0] != "/api/users" || paths[1] != "/api/users" {
+		t.Errorf("Unexpected paths: %v", paths)
+	}
+}
@@ -0,0 +1,291 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaults of CircuitBreaker
+const (
+	defaultBreakerConsecutiveFailures = 5
+	defaultBreakerCoolDown            = 30 * time.Second
+	defaultBreakerWindow              = time.Minute
+	defaultBreakerMinRequests         = 10
+)
+
+// ErrCircuitOpen returned without doing the request when the circuit of the request is open, never retried
+var ErrCircuitOpen = errors.New("ray.circuit.open")
+
+// CircuitState state of a circuit
+type CircuitState int
+
+const (
+	// CircuitClosed requests go through, failures are counted
+	CircuitClosed CircuitState = iota
+	// CircuitOpen requests fail fast with ErrCircuitOpen until the cool-down elapses
+	CircuitOpen
+	// CircuitHalfOpen a few trial requests go through, they close the circuit on success and open it again on failure
+	CircuitHalfOpen
+)
+
+// String implements fmt.Stringer
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker circuit breaker with one circuit per host, or per key of Key
+// a circuit opens after ConsecutiveFailures failures in a row, or when the failure rate of the window reaches FailureRate,
+// create it once and share it between requests with WithCircuitBreaker, eg. in Config.Options
+type CircuitBreaker struct {
+	// Key key of the circuit of a request, the host of the url if nil
+	Key func(req *http.Request) string
+	// ConsecutiveFailures consecutive failures opening the circuit, 5 if neither threshold is set
+	ConsecutiveFailures int
+	// FailureRate failure rate of the window opening the circuit, between 0 and 1, 0 disables it
+	FailureRate float64
+	// MinRequests requests of the window before FailureRate applies, 10 if 0
+	MinRequests int
+	// Window window of the failure rate, one minute if 0
+	Window time.Duration
+	// CoolDown time the circuit stays open before trial requests are let through, 30s if 0
+	CoolDown time.Duration
+	// HalfOpenRequests trial requests of a half-open circuit which must all succeed to close it, 1 if 0
+	HalfOpenRequests int
+	// IsFailure classify the result of a request, transport errors and 5xx responses by default
+	IsFailure func(resp *http.Response, err error) bool
+	// OnStateChange called after the state of a circuit changed, eg. for alerting
+	OnStateChange func(key string, from CircuitState, to CircuitState)
+	// Now clock, time.Now if nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit state of a key
+type circuit struct {
+	state       CircuitState
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int
+	successes   int
+	// generation incremented on every state change
+	generation int
+}
+
+// stateChange pending OnStateChange call
+type stateChange struct {
+	key  string
+	from CircuitState
+	to   CircuitState
+}
+
+// State state of the circuit of key
+func (b *CircuitBreaker) State(key string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return CircuitClosed
+	}
+	if c.state == CircuitOpen && b.now().Sub(c.openedAt) >= b.coolDown() {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+// Reset close all circuits
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuits = nil
+}
+
+// allow check the circuit of the request, report must be called with the result of the request
+// a nil breaker allows everything
+func (b *CircuitBreaker) allow(req *http.Request) (report func(resp *http.Response, err error), err error) {
+	if b == nil {
+		return func(resp *http.Response, err error) {}, nil
+	}
+	key := req.URL.Host
+	if b.Key != nil {
+		key = b.Key(req)
+	}
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{windowStart: b.now()}
+		b.circuits[key] = c
+	}
+	if c.state == CircuitOpen {
+		if b.now().Sub(c.openedAt) < b.coolDown() {
+			return nil, errors.WithMessagef(ErrCircuitOpen, "[key]%s", key)
+		}
+		changes = append(changes, b.transit(key, c, CircuitHalfOpen))
+	}
+	if c.state == CircuitHalfOpen {
+		if c.trials >= b.halfOpenRequests() {
+			return nil, errors.WithMessagef(ErrCircuitOpen, "[key]%s", key)
+		}
+		c.trials++
+	}
+	generation := c.generation
+	var once sync.Once
+	return func(resp *http.Response, err error) {
+		once.Do(func() {
+			b.report(key, c, generation, resp, err)
+		})
+	}, nil
+}
+
+// report record the result of a request allowed in generation
+func (b *CircuitBreaker) report(key string, c *circuit, generation int, resp *http.Response, err error) {
+	var changes []stateChange
+	defer func() { b.notify(changes) }()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// the caller gave up, the upstream is not to blame
+	canceled := errors.Is(err, context.Canceled)
+	failure := !canceled && b.isFailure(resp, err)
+	// the circuit changed meanwhile, eg. a request of the closed circuit finishing after it was opened
+	if c.generation != generation {
+		return
+	}
+	switch c.state {
+	case CircuitClosed:
+		if canceled {
+			return
+		}
+		now := b.now()
+		if now.Sub(c.windowStart) >= b.window() {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+		c.requests++
+		if !failure {
+			c.consecutive = 0
+			return
+		}
+		c.failures++
+		c.consecutive++
+		if b.tripped(c) {
+			changes = append(changes, b.transit(key, c, CircuitOpen))
+		}
+	case CircuitHalfOpen:
+		switch {
+		case canceled:
+			c.trials--
+		case failure:
+			changes = append(changes, b.transit(key, c, CircuitOpen))
+		default:
+			c.successes++
+			if c.successes >= b.halfOpenRequests() {
+				changes = append(changes, b.transit(key, c, CircuitClosed))
+			}
+		}
+	}
+}
+
+// tripped whether a closed circuit must open
+func (b *CircuitBreaker) tripped(c *circuit) bool {
+	consecutive := b.ConsecutiveFailures
+	if consecutive <= 0 && b.FailureRate <= 0 {
+		consecutive = defaultBreakerConsecutiveFailures
+	}
+	if consecutive > 0 && c.consecutive >= consecutive {
+		return true
+	}
+	minRequests := b.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultBreakerMinRequests
+	}
+	return b.FailureRate > 0 && c.requests >= minRequests && float64(c.failures)/float64(c.requests) >= b.FailureRate
+}
+
+// transit move the circuit to state, the caller holds b.mu
+func (b *CircuitBreaker) transit(key string, c *circuit, state CircuitState) stateChange {
+	change := stateChange{key: key, from: c.state, to: state}
+	now := b.now()
+	c.state = state
+	c.generation++
+	c.trials, c.successes = 0, 0
+	switch state {
+	case CircuitOpen:
+		c.openedAt = now
+	case CircuitClosed:
+		c.consecutive, c.requests, c.failures, c.windowStart = 0, 0, 0, now
+	}
+	return change
+}
+
+// notify call OnStateChange, without holding b.mu
+func (b *CircuitBreaker) notify(changes []stateChange) {
+	if b.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.OnStateChange(change.key, change.from, change.to)
+	}
+}
+
+func (b *CircuitBreaker) isFailure(resp *http.Response, err error) bool {
+	if b.IsFailure != nil {
+		return b.IsFailure(resp, err)
+	}
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown > 0 {
+		return b.CoolDown
+	}
+	return defaultBreakerCoolDown
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window > 0 {
+		return b.Window
+	}
+	return defaultBreakerWindow
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests > 0 {
+		return b.HalfOpenRequests
+	}
+	return 1
+}
+
+// WithCircuitBreaker set circuit breaker, share one breaker between the requests to the same upstreams
+func WithCircuitBreaker(b *CircuitBreaker) OptionHandle {
+	return func(opt *Options) {
+		opt.CircuitBreaker = b
+	}
+}
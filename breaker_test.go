@@ -0,0 +1,179 @@
+package ray
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+// testClock manual clock of the tests
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/down").Reply(http.StatusServiceUnavailable, "down").Reply(http.StatusServiceUnavailable, "down").Reply(http.StatusServiceUnavailable, "down").Reply(http.StatusOK, "up")
+	clock := &testClock{now: time.Now()}
+	var changes []string
+	breaker := &CircuitBreaker{
+		ConsecutiveFailures: 3,
+		CoolDown:            time.Minute,
+		Now:                 clock.Now,
+		OnStateChange: func(key string, from CircuitState, to CircuitState) {
+			changes = append(changes, key+" "+from.String()+"->"+to.String())
+		},
+	}
+	client := NewClient(Config{RetryTimes: 5, Options: []OptionHandle{WithTransport(mock), WithCircuitBreaker(breaker), WithLogger(func(opt *Options, err error) error { return nil })}})
+
+	// the retries stop as soon as the circuit opens
+	_, err := client.DoRetry(client.NewOptions(WithURL("http://api.test/down")))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/down", 3)
+	if breaker.State("api.test") != CircuitOpen {
+		t.Errorf("Unexpected state: %s", breaker.State("api.test"))
+	}
+
+	// fail fast while open
+	_, err = client.Do(client.NewOptions(WithURL("http://api.test/down")))
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/down", 3)
+
+	// a trial request closes the circuit after the cool-down
+	clock.Add(time.Minute)
+	body, err := client.Do(client.NewOptions(WithURL("http://api.test/down")))
+	if err != nil || string(body) != "up" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	if breaker.State("api.test") != CircuitClosed {
+		t.Errorf("Unexpected state: %s", breaker.State("api.test"))
+	}
+	expected := "api.test closed->open,api.test open->half-open,api.test half-open->closed"
+	if strings.Join(changes, ",") != expected {
+		t.Errorf("Unexpected state changes: %v", changes)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/a/*").Fail(nil)
+	mock.On(http.MethodGet, "/b/*").Reply(http.StatusOK, "b")
+	clock := &testClock{now: time.Now()}
+	breaker := &CircuitBreaker{
+		// circuit per first path segment
+		Key: func(req *http.Request) string {
+			return strings.Split(req.URL.Path, "/")[1]
+		},
+		ConsecutiveFailures: 1,
+		CoolDown:            time.Second,
+		HalfOpenRequests:    2,
+		Now:                 clock.Now,
+	}
+	opts := func(url string) Options {
+		return NewOptions(WithURL(url), WithTransport(mock), WithCircuitBreaker(breaker), WithRetryTimes(0), WithLogger(func(opt *Options, err error) error { return nil }))
+	}
+
+	Do(opts("http://api.test/a/1"))
+	if breaker.State("a") != CircuitOpen || breaker.State("b") != CircuitClosed {
+		t.Errorf("Unexpected states: %s %s", breaker.State("a"), breaker.State("b"))
+	}
+	_, err := Do(opts("http://api.test/b/1"))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// a failed trial opens the circuit again
+	clock.Add(time.Second)
+	_, err = Do(opts("http://api.test/a/1"))
+	if err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if breaker.State("a") != CircuitOpen {
+		t.Errorf("Unexpected state: %s", breaker.State("a"))
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/a/*", 2)
+
+	// the half-open circuit lets HalfOpenRequests trials through at once
+	clock.Add(time.Second)
+	reports := []func(resp *http.Response, err error){}
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/a/1", nil)
+	for i := 0; i < 3; i++ {
+		report, err := breaker.allow(req)
+		if i < 2 && err != nil || i == 2 && !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Unexpected error of trial %d: %v", i, err)
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+	}
+	for _, report := range reports {
+		report(&http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	if breaker.State("a") != CircuitClosed {
+		t.Errorf("Unexpected state: %s", breaker.State("a"))
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	breaker := &CircuitBreaker{FailureRate: 0.5, MinRequests: 4, Window: time.Minute, Now: clock.Now}
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/", nil)
+	result := func(code int) {
+		report, err := breaker.allow(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		report(&http.Response{StatusCode: code}, nil)
+	}
+
+	// no consecutive failures, but half of the requests failed
+	result(http.StatusInternalServerError)
+	result(http.StatusOK)
+	result(http.StatusInternalServerError)
+	if breaker.State("api.test") != CircuitClosed {
+		t.Errorf("Unexpected state before MinRequests: %s", breaker.State("api.test"))
+	}
+	result(http.StatusOK)
+	if breaker.State("api.test") != CircuitClosed {
+		t.Errorf("Unexpected state after a success: %s", breaker.State("api.test"))
+	}
+
+	// a new window
+	breaker.Reset()
+	result(http.StatusOK)
+	result(http.StatusOK)
+	clock.Add(time.Minute)
+	result(http.StatusInternalServerError)
+	result(http.StatusOK)
+	result(http.StatusInternalServerError)
+	result(http.StatusOK)
+	if breaker.State("api.test") != CircuitClosed {
+		t.Errorf("Unexpected state: %s", breaker.State("api.test"))
+	}
+	result(http.StatusInternalServerError)
+	if breaker.State("api.test") != CircuitOpen {
+		t.Errorf("Unexpected state: %s", breaker.State("api.test"))
+	}
+}
@@ -0,0 +1,182 @@
+package ray
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBulkheadFull matches the BulkheadError of a request rejected by a Bulkhead, never retried
+var ErrBulkheadFull = errors.New("ray.bulkhead.full")
+
+// BulkheadError returned when the queue of the bulkhead is full or the wait for a slot timed out
+type BulkheadError struct {
+	Key string
+	// Timeout the request waited QueueTimeout in the queue, the queue was full otherwise
+	Timeout bool
+}
+
+// Error implements error
+func (e *BulkheadError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("ray.bulkhead.queue.timeout,[key]%s", e.Key)
+	}
+	return fmt.Sprintf("ray.bulkhead.full,[key]%s", e.Key)
+}
+
+// Is errors.Is(err, ErrBulkheadFull) holds
+func (e *BulkheadError) Is(target error) bool {
+	return target == ErrBulkheadFull
+}
+
+// BulkheadStats current counts of a key
+type BulkheadStats struct {
+	InFlight int
+	Queued   int
+}
+
+// Bulkhead bound the in-flight requests per host, or per key of Key
+// a request over MaxConcurrent waits in a FIFO queue of MaxQueue requests at most, create it once and share it with WithBulkhead
+type Bulkhead struct {
+	// MaxConcurrent max in-flight requests of a key, 1 if 0
+	MaxConcurrent int
+	// MaxQueue max requests waiting for a slot, requests are rejected at once if 0
+	MaxQueue int
+	// QueueTimeout max wait for a slot, only bounded by the request context if 0
+	QueueTimeout time.Duration
+	// Key key of the compartment of a request, the host of the url if nil
+	Key func(req *http.Request) string
+
+	mu           sync.Mutex
+	compartments map[string]*compartment
+}
+
+// compartment slots of a key, a released slot is handed over to the first waiter
+type compartment struct {
+	inFlight int
+	waiters  []chan struct{}
+}
+
+// Stats current counts of key
+func (b *Bulkhead) Stats(key string) BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.compartments[key]
+	if !ok {
+		return BulkheadStats{}
+	}
+	return BulkheadStats{InFlight: c.inFlight, Queued: len(c.waiters)}
+}
+
+// AllStats current counts of all keys with in-flight or queued requests
+func (b *Bulkhead) AllStats() map[string]BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make(map[string]BulkheadStats, len(b.compartments))
+	for key, c := range b.compartments {
+		stats[key] = BulkheadStats{InFlight: c.inFlight, Queued: len(c.waiters)}
+	}
+	return stats
+}
+
+// acquire take a slot for the request, release must be called once the request is done, it may be called more than once
+// a nil bulkhead does not limit
+func (b *Bulkhead) acquire(req *http.Request) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	key := req.URL.Host
+	if b.Key != nil {
+		key = b.Key(req)
+	}
+	b.mu.Lock()
+	if b.compartments == nil {
+		b.compartments = map[string]*compartment{}
+	}
+	c, ok := b.compartments[key]
+	if !ok {
+		c = &compartment{}
+		b.compartments[key] = c
+	}
+	if c.inFlight < b.maxConcurrent() && len(c.waiters) == 0 {
+		c.inFlight++
+		b.mu.Unlock()
+		return b.releaser(key, c), nil
+	}
+	if len(c.waiters) >= b.MaxQueue {
+		b.mu.Unlock()
+		return nil, &BulkheadError{Key: key}
+	}
+	ready := make(chan struct{}, 1)
+	c.waiters = append(c.waiters, ready)
+	b.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if b.QueueTimeout > 0 {
+		timer := time.NewTimer(b.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+		return b.releaser(key, c), nil
+	case <-timeout:
+		err = &BulkheadError{Key: key, Timeout: true}
+	case <-req.Context().Done():
+		err = errors.WithMessagef(req.Context().Err(), "ray.bulkhead.wait,[key]%s", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, w := range c.waiters {
+		if w == ready {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return nil, err
+		}
+	}
+	// the slot was handed over meanwhile, pass it on
+	b.handOver(key, c)
+	return nil, err
+}
+
+// releaser release func of a slot of c
+func (b *Bulkhead) releaser(key string, c *compartment) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.handOver(key, c)
+		})
+	}
+}
+
+// handOver give a released slot to the first waiter, the caller holds b.mu
+func (b *Bulkhead) handOver(key string, c *compartment) {
+	if len(c.waiters) > 0 {
+		ready := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		ready <- struct{}{}
+		return
+	}
+	c.inFlight--
+	if c.inFlight == 0 {
+		delete(b.compartments, key)
+	}
+}
+
+func (b *Bulkhead) maxConcurrent() int {
+	if b.MaxConcurrent > 0 {
+		return b.MaxConcurrent
+	}
+	return 1
+}
+
+// WithBulkhead set bulkhead, share one bulkhead between the requests to the same upstreams
+func WithBulkhead(b *Bulkhead) OptionHandle {
+	return func(opt *Options) {
+		opt.Bulkhead = b
+	}
+}
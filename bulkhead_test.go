@@ -0,0 +1,181 @@
+package ray
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+// eventually wait until cond holds
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected timeout waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/slow").Delay(100*time.Millisecond).Reply(http.StatusOK, "slow")
+	mock.On(http.MethodGet, "/fast").Reply(http.StatusOK, "fast")
+	bulkhead := &Bulkhead{MaxConcurrent: 2, MaxQueue: 1}
+	client := NewClient(Config{RetryTimes: 3, Options: []OptionHandle{WithTransport(mock), WithBulkhead(bulkhead)}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := client.DoRetry(client.NewOptions(WithURL("http://slow.test/slow")))
+			if err != nil || string(body) != "slow" {
+				t.Errorf("Unexpected response: %v %s", err, string(body))
+			}
+		}()
+	}
+	eventually(t, func() bool {
+		return bulkhead.Stats("slow.test") == BulkheadStats{InFlight: 2, Queued: 1}
+	})
+
+	// the queue is full, the rejection is not retried
+	_, err := client.DoRetry(client.NewOptions(WithURL("http://slow.test/slow")))
+	var be *BulkheadError
+	if !errors.As(err, &be) || !errors.Is(err, ErrBulkheadFull) || be.Key != "slow.test" || be.Timeout {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// other hosts have their own compartment
+	body, err := client.DoRetry(client.NewOptions(WithURL("http://fast.test/fast")))
+	if err != nil || string(body) != "fast" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodGet, "/slow", 3)
+	if len(bulkhead.AllStats()) != 0 {
+		t.Errorf("Unexpected stats: %v", bulkhead.AllStats())
+	}
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/slow").Delay(200*time.Millisecond).Reply(http.StatusOK, "slow")
+	bulkhead := &Bulkhead{MaxQueue: 2, QueueTimeout: 20 * time.Millisecond}
+	do := func(ctx context.Context) error {
+		_, err := Do(NewOptions(WithURL("http://slow.test/slow"), WithTransport(mock), WithBulkhead(bulkhead), WithContext(ctx)))
+		return err
+	}
+
+	done := make(chan error)
+	go func() { done <- do(context.Background()) }()
+	eventually(t, func() bool { return bulkhead.Stats("slow.test").InFlight == 1 })
+
+	var be *BulkheadError
+	if err := do(context.Background()); !errors.As(err, &be) || !be.Timeout {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// the canceled request leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := do(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if stats := bulkhead.Stats("slow.test"); stats != (BulkheadStats{InFlight: 1}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestBulkheadHandOver(t *testing.T) {
+	bulkhead := &Bulkhead{MaxConcurrent: 1, MaxQueue: 10}
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/", nil)
+	release, err := bulkhead.acquire(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the waiters get the slot in order
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := bulkhead.acquire(req)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release()
+		}(i)
+		eventually(t, func() bool { return bulkhead.Stats("api.test").Queued == i+1 })
+	}
+	release()
+	// a second release is a no-op
+	release()
+	wg.Wait()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("Unexpected order: %v", order)
+	}
+	if stats := bulkhead.Stats("api.test"); stats != (BulkheadStats{}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestBulkheadCircuitOpen(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/down").Reply(http.StatusServiceUnavailable, "down").Reply(http.StatusOK, "up")
+	clock := &testClock{now: time.Now()}
+	breaker := &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute, Now: clock.Now}
+	bulkhead := &Bulkhead{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Second}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithCircuitBreaker(breaker), WithBulkhead(bulkhead), WithLogger(func(opt *Options, err error) error { return nil })}})
+	do := func() error {
+		_, err := client.Do(client.NewOptions(WithURL("http://api.test/down")))
+		return err
+	}
+	if err := do(); err == nil {
+		t.Errorf("Unexpected success")
+	}
+
+	// the slot is taken, an open circuit fails at once without queueing
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/", nil)
+	release, err := bulkhead.acquire(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	start := time.Now()
+	if err := do(); !errors.Is(err, ErrCircuitOpen) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Unexpected error: %v after %v", err, time.Since(start))
+	}
+	if stats := bulkhead.Stats("api.test"); stats != (BulkheadStats{InFlight: 1}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// a trial rejected by the bulkhead does not use up the half-open circuit
+	clock.Add(2 * time.Minute)
+	bulkhead.QueueTimeout = time.Millisecond
+	var be *BulkheadError
+	if err := do(); !errors.As(err, &be) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	release()
+	if err := do(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if breaker.State("api.test") != CircuitClosed {
+		t.Errorf("Unexpected state: %s", breaker.State("api.test"))
+	}
+}
@@ -0,0 +1,309 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CacheStatusHeader response header telling how the cache answered
+const CacheStatusHeader = "X-Ray-Cache"
+
+// values of CacheStatusHeader
+const (
+	// CacheHit fresh response of the cache
+	CacheHit = "HIT"
+	// CacheMiss response of the upstream
+	CacheMiss = "MISS"
+	// CacheRevalidated stale response of the cache the upstream confirmed with 304
+	CacheRevalidated = "REVALIDATED"
+	// CacheStale stale response of the cache served while it is revalidated in the background
+	CacheStale = "STALE"
+)
+
+// revalidateTimeout timeout of a background revalidation
+const revalidateTimeout = 30 * time.Second
+
+// defaultCacheEntries max entries of the default memory storage
+const defaultCacheEntries = 1000
+
+// Cache RFC 7234 private http cache of GET and HEAD responses
+// freshness follows Cache-Control, Expires and the Last-Modified heuristic, stale responses are revalidated with
+// If-None-Match/If-Modified-Since, Vary is honored and stale-while-revalidate serves stale responses while revalidating,
+// create it once and share it with WithCache
+// the cache answers before the circuit breaker, the bulkhead, the rate limiter and the balancer, responses are keyed by the url of the caller,
+// the Authorization and Cookie headers are part of the key, an unsafe request only invalidates the responses of its own credentials,
+// but credentials added by hooks or token sources are not, share a cache between requests of the same identity,
+// responses of redirected requests and streams are not cached
+type Cache struct {
+	// Storage storage of the responses, a memory LRU of 1000 entries if nil
+	Storage CacheStorage
+	// Shared behave like a shared cache, s-maxage applies and private responses are not stored
+	Shared bool
+	// Now clock, time.Now if nil
+	Now func() time.Time
+
+	once         sync.Once
+	revalidating sync.Map
+}
+
+// cacheLookup a request the cache did not answer, complete stores or revalidates with its response
+type cacheLookup struct {
+	cache *Cache
+	key   string
+	// req request of the caller without credentials, the key and Vary are built from it
+	req *http.Request
+	// entry stale entry revalidated by the request, nil if none
+	entry *CacheEntry
+	// invalidate the request is unsafe, a success invalidates the url
+	invalidate bool
+	reqTime    time.Time
+}
+
+// storage storage of the cache
+func (c *Cache) storage() CacheStorage {
+	c.once.Do(func() {
+		if c.Storage == nil {
+			c.Storage = NewMemoryCacheStorage(defaultCacheEntries)
+		}
+	})
+	return c.Storage
+}
+
+func (c *Cache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// lookup look up the request of opts, a response or an error means the cache answered,
+// otherwise the request goes upstream and the lookup completes it, a nil cache returns a nil lookup
+// do sends the background revalidation of a stale response
+func (c *Cache) lookup(opts *Options, do func(opts Options) (*Response, error)) (*cacheLookup, *Response, error) {
+	if c == nil {
+		return nil, nil, nil
+	}
+	req, err := newBareRequest(context.Background(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	l := &cacheLookup{cache: c, req: req, reqTime: c.now()}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		l.invalidate = req.Method != http.MethodOptions && req.Method != http.MethodTrace
+		return l, nil, nil
+	}
+	// the caller handles ranges and its own validators
+	if req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil, nil, nil
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return nil, nil, nil
+	}
+	l.key = cacheKey(req.Method, req)
+	_, onlyIfCached := reqCC["only-if-cached"]
+	entry, ok := c.storage().Get(l.key)
+	if !ok || entry.varies(req) {
+		if onlyIfCached {
+			return nil, nil, gatewayTimeout()
+		}
+		return l, nil, nil
+	}
+	age := entry.age(l.reqTime)
+	if !opts.cacheRevalidate {
+		lifetime := entry.lifetime(c.Shared)
+		respCC := parseCacheControl(entry.Header)
+		_, reqNoCache := reqCC["no-cache"]
+		reqNoCache = reqNoCache || strings.Contains(req.Header.Get("Pragma"), "no-cache")
+		_, respNoCache := respCC["no-cache"]
+		fresh := age < lifetime && !reqNoCache && !respNoCache
+		if maxAge, ok := ccSeconds(reqCC, "max-age"); ok && age > maxAge {
+			fresh = false
+		}
+		if fresh {
+			resp, err := entry.response(req, age, CacheHit)
+			return nil, resp, err
+		}
+		_, mustRevalidate := respCC["must-revalidate"]
+		if swr, ok := ccSeconds(respCC, "stale-while-revalidate"); ok && age < lifetime+swr && !reqNoCache && !respNoCache && !mustRevalidate {
+			c.revalidateAsync(opts, l.key, do)
+			resp, err := entry.response(req, age, CacheStale)
+			return nil, resp, err
+		}
+	}
+	if onlyIfCached {
+		return nil, nil, gatewayTimeout()
+	}
+	if entry.hasValidators() {
+		l.entry = entry
+	}
+	return l, nil, nil
+}
+
+// revalidateAsync revalidate the request in the background, at most one revalidation per key at a time
+// it goes through the resilience layers like any request
+func (c *Cache) revalidateAsync(opts *Options, key string, do func(opts Options) (*Response, error)) {
+	if _, loaded := c.revalidating.LoadOrStore(key, true); loaded {
+		return
+	}
+	bg := *opts
+	bg.cacheRevalidate = true
+	// the context of the caller ends with the call which served the stale response
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(opts.context()), revalidateTimeout)
+	bg.Context = ctx
+	go func() {
+		defer cancel()
+		defer c.revalidating.Delete(key)
+		do(bg)
+	}()
+}
+
+// condition add the validators of the stale entry to the request
+func (l *cacheLookup) condition(req *http.Request) {
+	if l == nil || l.entry == nil {
+		return
+	}
+	if etag := l.entry.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := l.entry.Header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// complete store the response of the upstream, or answer a 304 of a revalidation with the stored response,
+// answered reports whether resp was replaced
+func (l *cacheLookup) complete(req *http.Request, resp *http.Response, body []byte, redirected bool) (answer *Response, answered bool, err error) {
+	if l == nil {
+		return nil, false, nil
+	}
+	c := l.cache
+	if l.invalidate {
+		// a successful unsafe request invalidates the cached responses of the url
+		if resp.StatusCode < http.StatusBadRequest {
+			c.storage().Delete(cacheKey(http.MethodGet, l.req))
+			c.storage().Delete(cacheKey(http.MethodHead, l.req))
+		}
+		return nil, false, nil
+	}
+	if l.entry != nil && resp.StatusCode == http.StatusNotModified {
+		now := c.now()
+		l.entry.update(resp.Header, l.reqTime, now)
+		c.storage().Set(l.key, l.entry)
+		answer, err = l.entry.response(l.req, l.entry.age(now), CacheRevalidated)
+		return answer, true, err
+	}
+	if redirected || !cacheable(req, resp, c.Shared) {
+		c.storage().Delete(l.key)
+		return nil, false, nil
+	}
+	entry := &CacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		VaryHeader:   http.Header{},
+		RequestTime:  l.reqTime,
+		ResponseTime: c.now(),
+	}
+	for _, name := range entry.vary() {
+		entry.VaryHeader[http.CanonicalHeaderKey(name)] = l.req.Header.Values(name)
+	}
+	c.storage().Set(l.key, entry)
+	resp.Header.Set(CacheStatusHeader, CacheMiss)
+	return nil, false, nil
+}
+
+// cacheable whether the response may be stored, RFC 7234 section 3
+func cacheable(req *http.Request, resp *http.Response, shared bool) bool {
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(resp.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	if _, ok := respCC["no-store"]; ok {
+		return false
+	}
+	_, public := respCC["public"]
+	if shared {
+		if _, ok := respCC["private"]; ok {
+			return false
+		}
+		_, sMaxAge := respCC["s-maxage"]
+		_, mustRevalidate := respCC["must-revalidate"]
+		if req.Header.Get("Authorization") != "" && !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	_, maxAge := respCC["max-age"]
+	explicit := maxAge || public || resp.Header.Get("Expires") != ""
+	if shared {
+		_, sMaxAge := respCC["s-maxage"]
+		explicit = explicit || sMaxAge
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return explicit && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusPartialContent
+}
+
+// cacheKey key of the responses of the request, requests of different credentials never share a response
+func cacheKey(method string, req *http.Request) string {
+	key := method + " " + req.URL.String()
+	if credentials := credentialKey(req.Header); credentials != "" {
+		key += "\ncredentials: " + credentials
+	}
+	return key
+}
+
+// gatewayTimeout error of an only-if-cached request which can not be answered by the cache
+func gatewayTimeout() error {
+	return errors.WithMessage(&StatusError{StatusCode: http.StatusGatewayTimeout, Header: http.Header{}}, "ray.cache.only.if.cached")
+}
+
+// parseCacheControl directives of the Cache-Control headers, names lowercased
+func parseCacheControl(header http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return cc
+}
+
+// ccSeconds delta-seconds argument of a directive
+func ccSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// WithCache set cache of the responses
+func WithCache(c *Cache) OptionHandle {
+	return func(opt *Options) {
+		opt.Cache = c
+	}
+}
@@ -0,0 +1,169 @@
+package ray
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+func TestCache(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").Header("If-None-Match", `"v1"`).ReplyWith(raytest.Response{StatusCode: http.StatusNotModified, Header: http.Header{"Cache-Control": {"max-age=60"}}})
+	mock.On(http.MethodGet, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}}, Body: []byte("v1")})
+	mock.On(http.MethodPost, "/item").Reply(http.StatusOK, "ok")
+	cache := &Cache{Now: clock.Now}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithCache(cache)}})
+	get := func(status string) {
+		t.Helper()
+		resp, err := client.DoResponse(client.NewOptions(WithURL("http://api.test/item")))
+		if err != nil || string(resp.Body) != "v1" || resp.Header.Get(CacheStatusHeader) != status {
+			t.Errorf("Unexpected response: %v %+v", err, resp)
+		}
+	}
+
+	get(CacheMiss)
+	clock.Add(30 * time.Second)
+	get(CacheHit)
+	mock.AssertCallCount(t, http.MethodGet, "/item", 1)
+
+	// a stale response is revalidated with its etag
+	clock.Add(31 * time.Second)
+	get(CacheRevalidated)
+	mock.AssertCallCount(t, http.MethodGet, "/item", 2)
+	calls := mock.Calls()
+	if inm := calls[len(calls)-1].Header.Get("If-None-Match"); inm != `"v1"` {
+		t.Errorf("Unexpected If-None-Match: %s", inm)
+	}
+	get(CacheHit)
+	mock.AssertCallCount(t, http.MethodGet, "/item", 2)
+
+	// an unsafe request invalidates the url
+	client.Do(client.NewOptions(WithURL("http://api.test/item"), WithMethod(http.MethodPost)))
+	get(CacheMiss)
+	mock.AssertCallCount(t, http.MethodGet, "/item", 3)
+
+	// the request may ask for a fresh response
+	get(CacheHit)
+	resp, err := client.DoResponse(client.NewOptions(WithURL("http://api.test/item"), WithHeader(map[string]string{"Cache-Control": "no-cache"})))
+	if err != nil || resp.Header.Get(CacheStatusHeader) != CacheRevalidated {
+		t.Errorf("Unexpected response: %v %+v", err, resp)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, Body: []byte("hello")})
+	mock.On(http.MethodGet, "/private").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"no-store"}}, Body: []byte("secret")})
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithCache(&Cache{})}})
+	get := func(url string, lang string) {
+		client.Do(client.NewOptions(WithURL(url), WithHeader(map[string]string{"Accept-Language": lang})))
+	}
+
+	get("http://api.test/item", "en")
+	get("http://api.test/item", "en")
+	mock.AssertCallCount(t, http.MethodGet, "/item", 1)
+	get("http://api.test/item", "zh")
+	mock.AssertCallCount(t, http.MethodGet, "/item", 2)
+
+	get("http://api.test/private", "en")
+	get("http://api.test/private", "en")
+	mock.AssertCallCount(t, http.MethodGet, "/private", 2)
+
+	// only-if-cached never reaches the upstream
+	_, err := client.Do(client.NewOptions(WithURL("http://api.test/private"), WithHeader(map[string]string{"Cache-Control": "only-if-cached"})))
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/private", 2)
+}
+
+func TestCacheCredentials(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/me").Header("Authorization", "Bearer alice").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}, Body: []byte("alice")})
+	mock.On(http.MethodGet, "/me").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}, Body: []byte("other")})
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithCache(&Cache{})}})
+	get := func(header map[string]string, expected string) {
+		t.Helper()
+		body, err := client.Do(client.NewOptions(WithURL("http://api.test/me"), WithHeader(header)))
+		if err != nil || string(body) != expected {
+			t.Errorf("Unexpected response: %v %s", err, body)
+		}
+	}
+
+	get(map[string]string{"Authorization": "Bearer alice"}, "alice")
+	get(map[string]string{"Authorization": "Bearer alice"}, "alice")
+	mock.AssertCallCount(t, http.MethodGet, "/me", 1)
+	// the response of alice is not served to other credentials
+	get(map[string]string{"Authorization": "Bearer bob"}, "other")
+	get(map[string]string{"Cookie": "session=carol"}, "other")
+	get(nil, "other")
+	mock.AssertCallCount(t, http.MethodGet, "/me", 4)
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	mock := raytest.New()
+	header := http.Header{"Cache-Control": {"max-age=10, stale-while-revalidate=60"}}
+	mock.On(http.MethodGet, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: header, Body: []byte("v1")}).ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: header, Body: []byte("v2")})
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithCache(&Cache{Now: clock.Now})}})
+	get := func() string {
+		body, _ := client.Do(client.NewOptions(WithURL("http://api.test/item")))
+		return string(body)
+	}
+
+	get()
+	clock.Add(20 * time.Second)
+	// the stale response is served at once and refreshed in the background
+	if body := get(); body != "v1" {
+		t.Errorf("Unexpected body: %s", body)
+	}
+	eventually(t, func() bool { return get() == "v2" })
+	mock.AssertCallCount(t, http.MethodGet, "/item", 2)
+
+	// past the stale window the request waits for the upstream
+	clock.Add(80 * time.Second)
+	if body := get(); body != "v2" {
+		t.Errorf("Unexpected body: %s", body)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/item", 3)
+}
+
+func TestCacheBeforeResilience(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=60"}}, Body: []byte("v1")})
+	mock.On(http.MethodGet, "/fail").Reply(http.StatusInternalServerError, "down")
+	key := func(req *http.Request) string { return "api" }
+	client := NewClient(Config{Options: []OptionHandle{
+		WithTransport(mock),
+		WithLogger(func(opt *Options, err error) error { return nil }),
+		WithCache(&Cache{}),
+		WithBalancer(&Balancer{Endpoints: []Endpoint{{URL: "http://a.test"}, {URL: "http://b.test"}}}),
+		WithRateLimiter(&RateLimiter{RateLimit: RateLimit{Rate: 0.001, Burst: 3}, Key: key, FailFast: true}),
+		WithCircuitBreaker(&CircuitBreaker{Key: key, ConsecutiveFailures: 1}),
+	}})
+
+	if _, err := client.Do(client.NewOptions(WithURL("/item"))); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// the failure opens the circuit, the next request fails fast
+	if _, err := client.Do(client.NewOptions(WithURL("/fail"))); err == nil {
+		t.Errorf("Unexpected success")
+	}
+	if _, err := client.Do(client.NewOptions(WithURL("/fail"))); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// hits take no token, pass the open circuit and are shared by the endpoints
+	for i := 0; i < 3; i++ {
+		resp, err := client.DoResponse(client.NewOptions(WithURL("/item")))
+		if err != nil || string(resp.Body) != "v1" || resp.Header.Get(CacheStatusHeader) != CacheHit {
+			t.Errorf("Unexpected response: %v %+v", err, resp)
+		}
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/item", 1)
+}
@@ -0,0 +1,292 @@
+package ray
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heuristicFreshness max freshness lifetime of the Last-Modified heuristic
+const heuristicFreshness = 24 * time.Hour
+
+// CacheStorage storage of the cached responses, safe for concurrent use
+type CacheStorage interface {
+	// Get entry of the key
+	Get(key string) (*CacheEntry, bool)
+	// Set store entry of the key
+	Set(key string, entry *CacheEntry)
+	// Delete remove entry of the key
+	Delete(key string)
+}
+
+// CacheEntry cached response
+type CacheEntry struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// VaryHeader values of the request headers named by Vary
+	VaryHeader http.Header `json:"vary_header,omitempty"`
+	// RequestTime time the request was sent
+	RequestTime time.Time `json:"request_time"`
+	// ResponseTime time the response was received
+	ResponseTime time.Time `json:"response_time"`
+}
+
+// vary names of the request headers named by Vary
+func (e *CacheEntry) vary() []string {
+	var names []string
+	for _, value := range e.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// varies whether the request selects another representation than the entry
+func (e *CacheEntry) varies(req *http.Request) bool {
+	for _, name := range e.vary() {
+		if name == "*" || strings.Join(req.Header.Values(name), ",") != strings.Join(e.VaryHeader.Values(name), ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// date Date of the response, the response time if missing
+func (e *CacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// age current age of the entry, RFC 7234 section 4.2.3
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	apparent := maxDuration(0, e.ResponseTime.Sub(e.date()))
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return maxDuration(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// lifetime freshness lifetime of the entry, RFC 7234 section 4.2.1
+func (e *CacheEntry) lifetime(shared bool) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if shared {
+		if sMaxAge, ok := ccSeconds(cc, "s-maxage"); ok {
+			return sMaxAge
+		}
+	}
+	if maxAge, ok := ccSeconds(cc, "max-age"); ok {
+		return maxAge
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		// an invalid date means already expired
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(e.date())
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		lifetime := e.date().Sub(lastModified) / 10
+		if lifetime > heuristicFreshness {
+			lifetime = heuristicFreshness
+		}
+		return lifetime
+	}
+	return 0
+}
+
+// hasValidators whether the entry can be revalidated
+func (e *CacheEntry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// update update the entry with the headers of a 304 response, RFC 7234 section 4.3.4
+func (e *CacheEntry) update(header http.Header, reqTime time.Time, respTime time.Time) {
+	for k, v := range header {
+		if k == "Content-Length" {
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.RequestTime = reqTime
+	e.ResponseTime = respTime
+}
+
+// response response of the entry, a StatusError if the stored status is not 200 like an upstream response
+func (e *CacheEntry) response(req *http.Request, age time.Duration, status string) (*Response, error) {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	header.Set(CacheStatusHeader, status)
+	body := append([]byte(nil), e.Body...)
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	if e.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: e.StatusCode, Header: header, Body: body}
+	}
+	return &Response{
+		StatusCode: e.StatusCode,
+		Header:     header,
+		Body:       body,
+		URL:        req.URL,
+	}, nil
+}
+
+// clone deep copy of the entry, storages hand out copies so callers can not race on them
+func (e *CacheEntry) clone() *CacheEntry {
+	cp := *e
+	cp.Header = e.Header.Clone()
+	cp.VaryHeader = e.VaryHeader.Clone()
+	cp.Body = append([]byte(nil), e.Body...)
+	return &cp
+}
+
+// MemoryCacheStorage in-memory LRU storage
+type MemoryCacheStorage struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// memoryCacheItem element of the LRU list
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStorage create an in-memory storage of at most maxEntries entries, the least recently used are evicted, unbounded if maxEntries <= 0
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	return &MemoryCacheStorage{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+// Get implements CacheStorage
+func (s *MemoryCacheStorage) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry.clone(), true
+}
+
+// Set implements CacheStorage
+func (s *MemoryCacheStorage) Set(key string, entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry.clone()
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&memoryCacheItem{key: key, entry: entry.clone()})
+	for s.maxEntries > 0 && s.lru.Len() > s.maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.entries, el.Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete implements CacheStorage
+func (s *MemoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+// Len number of entries
+func (s *MemoryCacheStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// DiskCacheStorage on-disk storage, one json file per entry named by the sha256 of the key, the size is not bounded
+// unreadable entries are misses, write errors are ignored, a cache never fails a request
+type DiskCacheStorage struct {
+	dir string
+}
+
+// NewDiskCacheStorage create an on-disk storage in dir, the directory is created if missing
+func NewDiskCacheStorage(dir string) (*DiskCacheStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStorage{dir: dir}, nil
+}
+
+// Get implements CacheStorage
+func (s *DiskCacheStorage) Get(key string) (*CacheEntry, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if entry.Header == nil {
+		entry.Header = http.Header{}
+	}
+	return &entry, true
+}
+
+// Set implements CacheStorage
+func (s *DiskCacheStorage) Set(key string, entry *CacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// write a temp file and rename it, readers never see a partial entry
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Delete implements CacheStorage
+func (s *DiskCacheStorage) Delete(key string) {
+	os.Remove(s.path(key))
+}
+
+func (s *DiskCacheStorage) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
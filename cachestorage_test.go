@@ -0,0 +1,84 @@
+package ray
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheStorage(t *testing.T) {
+	s := NewMemoryCacheStorage(2)
+	s.Set("a", &CacheEntry{Header: http.Header{}, Body: []byte("a")})
+	s.Set("b", &CacheEntry{Header: http.Header{}, Body: []byte("b")})
+	// a is used recently, b is evicted
+	s.Get("a")
+	s.Set("c", &CacheEntry{Header: http.Header{}, Body: []byte("c")})
+	if _, ok := s.Get("b"); ok || s.Len() != 2 {
+		t.Errorf("Unexpected entries: %d", s.Len())
+	}
+	entry, ok := s.Get("a")
+	if !ok || string(entry.Body) != "a" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	// the storage keeps its own copy
+	entry.Body[0] = 'x'
+	if entry, _ := s.Get("a"); string(entry.Body) != "a" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	s.Delete("a")
+	if _, ok := s.Get("a"); ok {
+		t.Errorf("Unexpected entry after delete")
+	}
+}
+
+func TestDiskCacheStorage(t *testing.T) {
+	s, err := NewDiskCacheStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now().Round(0)
+	s.Set("GET http://api.test/item", &CacheEntry{
+		StatusCode:   http.StatusOK,
+		Header:       http.Header{"Etag": {`"v1"`}},
+		Body:         []byte{0xff, 0x00},
+		RequestTime:  now,
+		ResponseTime: now,
+	})
+	entry, ok := s.Get("GET http://api.test/item")
+	if !ok || entry.StatusCode != http.StatusOK || entry.Header.Get("ETag") != `"v1"` || string(entry.Body) != "\xff\x00" || !entry.ResponseTime.Equal(now) {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	s.Delete("GET http://api.test/item")
+	if _, ok := s.Get("GET http://api.test/item"); ok {
+		t.Errorf("Unexpected entry after delete")
+	}
+}
+
+func TestCacheEntryLifetime(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		header   http.Header
+		shared   bool
+		lifetime time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=60"}}, false, time.Minute},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, false, time.Minute},
+		{http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, true, 10 * time.Second},
+		{http.Header{"Expires": {date.Add(time.Hour).Format(http.TimeFormat)}}, false, time.Hour},
+		{http.Header{"Expires": {"0"}}, false, 0},
+		{http.Header{"Last-Modified": {date.Add(-10 * time.Hour).Format(http.TimeFormat)}}, false, time.Hour},
+		{http.Header{}, false, 0},
+	} {
+		c.header.Set("Date", date.Format(http.TimeFormat))
+		entry := &CacheEntry{Header: c.header, RequestTime: date, ResponseTime: date}
+		if lifetime := entry.lifetime(c.shared); lifetime != c.lifetime {
+			t.Errorf("Unexpected lifetime of %v: %v", c.header, lifetime)
+		}
+	}
+
+	// the age includes the Age header and the time spent in the cache
+	entry := &CacheEntry{Header: http.Header{"Age": {"30"}}, RequestTime: date, ResponseTime: date.Add(time.Second)}
+	if age := entry.age(date.Add(11 * time.Second)); age != 41*time.Second {
+		t.Errorf("Unexpected age: %v", age)
+	}
+}
@@ -0,0 +1,99 @@
+package ray
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Config client level defaults
+// a Config is never mutated once it is stored in a Client, updates replace the whole snapshot
+type Config struct {
+	// Timeout default timeout of a single attempt
+	Timeout time.Duration
+	// RetryTimes default retry times
+	RetryTimes int
+	// Proxy default proxy, the environment is used if empty
+	Proxy string
+	// Logger default logger, used when Options.Logger is nil
+	Logger LoggerHandle
+	// Options default request options, applied by NewOptions before the options of the call
+	Options []OptionHandle
+}
+
+// Client http client with its own configuration, safe for concurrent use
+type Client struct {
+	config atomic.Pointer[Config]
+}
+
+// defaultClient client used by the package level functions
+var defaultClient = NewClient(Config{
+	Timeout:    3 * time.Second,
+	RetryTimes: 2,
+	Logger:     StdLogger,
+})
+
+// NewClient new client with the config
+func NewClient(cfg Config) *Client {
+	c := &Client{}
+	c.store(cfg)
+	return c
+}
+
+// DefaultClient client used by the package level functions and updated by the global setters
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// Config snapshot of the client config
+func (c *Client) Config() Config {
+	return *c.config.Load()
+}
+
+// store store a copy of cfg
+func (c *Client) store(cfg Config) {
+	cfg.Options = append([]OptionHandle(nil), cfg.Options...)
+	c.config.Store(&cfg)
+}
+
+// update apply fn on a copy of the current config and swap it in atomically
+func (c *Client) update(fn func(cfg *Config)) {
+	for {
+		old := c.config.Load()
+		cfg := *old
+		cfg.Options = append([]OptionHandle(nil), old.Options...)
+		fn(&cfg)
+		if c.config.CompareAndSwap(old, &cfg) {
+			return
+		}
+	}
+}
+
+// NewOptions new options with the defaults of the client
+func (c *Client) NewOptions(opts ...OptionHandle) Options {
+	cfg := c.config.Load()
+	o := Options{
+		Method:          "GET",
+		Timeout:         int(cfg.Timeout / time.Second),
+		TimeoutDuration: cfg.Timeout,
+		timeoutSeconds:  int(cfg.Timeout / time.Second),
+		RetryTimes:      cfg.RetryTimes,
+	}
+	for _, opt := range cfg.Options {
+		opt(&o)
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// prepare bind the options to the config snapshot of the client, the client doing the request decides the defaults
+// and do the work shared by all attempts, eg. compressing the body
+func (c *Client) prepare(opts *Options) error {
+	opts.config = c.config.Load()
+	// the attempts of the call share the endpoints tried
+	if opts.Balancer != nil {
+		opts.Context = opts.Balancer.track(opts.context())
+	}
+	return compressBody(opts)
+}
@@ -0,0 +1,103 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientConfig(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Header.Get("X-Library")))
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	logged := map[string]int{}
+	newLibraryClient := func(name string) *Client {
+		return NewClient(Config{
+			Timeout:    time.Second,
+			RetryTimes: 1,
+			Logger: func(opt *Options, err error) error {
+				mu.Lock()
+				logged[name]++
+				mu.Unlock()
+				return nil
+			},
+			Options: []OptionHandle{WithHeader(map[string]string{"X-Library": name})},
+		})
+	}
+	a := newLibraryClient("a")
+	b := newLibraryClient("b")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			resp, err := a.Get(context.Background(), server.URL, nil, map[string]string{"X-Trace": "1"})
+			if err != nil || string(resp) != "a" {
+				t.Errorf("Unexpected response: %s, %v", resp, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			resp, err := b.Get(context.Background(), server.URL)
+			if err != nil || string(resp) != "b" {
+				t.Errorf("Unexpected response: %s, %v", resp, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// global setters only touch the default client
+			SetDefaultRetryTimesAndTimeout(3, 2)
+			SetGlobalLogger(StdLogger)
+		}()
+	}
+	wg.Wait()
+
+	if logged["a"] != 10 || logged["b"] != 10 {
+		t.Errorf("Unexpected logger calls: %v", logged)
+	}
+	if a.Config().Timeout != time.Second || a.NewOptions().RetryTimes != 1 {
+		t.Errorf("Unexpected client config: %+v", a.Config())
+	}
+}
+
+func TestDefaultClientSetters(t *testing.T) {
+	old := DefaultClient().Config()
+	defer SetDefaultRetryTimesAndTimeout(int(old.Timeout/time.Second), old.RetryTimes)
+
+	SetDefaultRetryTimesAndTimeout(7, 0)
+	opts := NewOptions()
+	if opts.timeout() != 7*time.Second || opts.RetryTimes != 1 {
+		t.Errorf("Unexpected default options: timeout %v, retry %d", opts.timeout(), opts.RetryTimes)
+	}
+}
+
+func TestOptionsTimeout(t *testing.T) {
+	client := NewClient(Config{Timeout: 1500 * time.Millisecond})
+	opts := client.NewOptions()
+	if timeout := opts.timeout(); timeout != 1500*time.Millisecond {
+		t.Errorf("Unexpected timeout: %v", timeout)
+	}
+	// a Timeout changed after NewOptions wins
+	opts.Timeout = 10
+	if timeout := opts.timeout(); timeout != 10*time.Second {
+		t.Errorf("Unexpected timeout: %v", timeout)
+	}
+	opts = client.NewOptions()
+	opts.TimeoutDuration = 2 * time.Second
+	if timeout := opts.timeout(); timeout != 2*time.Second {
+		t.Errorf("Unexpected timeout: %v", timeout)
+	}
+	opts = client.NewOptions(WithTimeoutDuration(500 * time.Millisecond))
+	if timeout := opts.timeout(); timeout != 500*time.Millisecond {
+		t.Errorf("Unexpected timeout: %v", timeout)
+	}
+}
@@ -0,0 +1,112 @@
+package ray
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certReloaders reloaders shared by all requests, keyed by cert and key file
+var certReloaders sync.Map
+
+// certCheckInterval minimum interval between two checks of the files by the requests of a shared transport
+var certCheckInterval = time.Second
+
+// certReloader load a client certificate from files and reload it when the files change on disk
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+}
+
+// loadCertReloader get the shared reloader of the files
+func loadCertReloader(certFile string, keyFile string) *certReloader {
+	r, _ := certReloaders.LoadOrStore(certFile+"\x00"+keyFile, &certReloader{certFile: certFile, keyFile: keyFile})
+	return r.(*certReloader)
+}
+
+// certificate return the cached certificate, reload it if the modification time of the files changed
+// the previous certificate is kept if the files are missing or broken in the middle of a rotation
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return r.fallback(errors.WithMessagef(err, "ray.clientcert.stat,[file]%s", r.certFile))
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return r.fallback(errors.WithMessagef(err, "ray.clientcert.stat,[file]%s", r.keyFile))
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.fallback(errors.WithMessagef(err, "ray.clientcert.load,[cert]%s,[key]%s", r.certFile, r.keyFile))
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	r.checked = time.Now()
+	return r.cert, nil
+}
+
+// changed whether the files changed since the certificate was loaded, checked at most once per certCheckInterval
+// nothing changed until a handshake loaded a certificate, the server may never ask for one
+func (r *certReloader) changed() bool {
+	r.mu.Lock()
+	if r.cert == nil || time.Since(r.checked) < certCheckInterval {
+		r.mu.Unlock()
+		return false
+	}
+	r.checked = time.Now()
+	r.mu.Unlock()
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// fallback return the previous certificate with a warning, or err if there is none
+func (r *certReloader) fallback(err error) (*tls.Certificate, error) {
+	if r.cert == nil {
+		return nil, err
+	}
+	warn("client certificate reload failed, keep using the previous one: " + err.Error())
+	return r.cert, nil
+}
+
+// WithClientCert set client certificate for mTLS from PEM files, the certificate is reloaded when the files change
+func WithClientCert(certFile string, keyFile string) OptionHandle {
+	return func(opt *Options) {
+		reloader := loadCertReloader(certFile, keyFile)
+		opt.ClientCertSource = reloader.certificate
+		opt.clientCertSourceID = reloader
+	}
+}
+
+// WithClientCertSource set client certificate source for mTLS, it is called on every tls handshake
+func WithClientCertSource(source func() (*tls.Certificate, error)) OptionHandle {
+	// the requests of the option share a transport, create the option once to keep the connections alive
+	id := new(byte)
+	return func(opt *Options) {
+		opt.ClientCertSource = source
+		opt.clientCertSourceID = id
+	}
+}
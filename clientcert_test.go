@@ -0,0 +1,224 @@
+package ray
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCA a throwaway certificate authority for tls tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ray test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue issue a certificate, return PEM encoded cert and key
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newMTLSTestServer start a server which requires a client certificate and echoes its common name
+func newMTLSTestServer(t *testing.T, ca *testCA) *httptest.Server {
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	return server
+}
+
+func TestClientCertReload(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	defer func() { certCheckInterval = interval }()
+	ca := newTestCA(t)
+	server := newMTLSTestServer(t, ca)
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	write := func(cn string, mod time.Time) {
+		certPEM, keyPEM := ca.issue(t, cn, x509.ExtKeyUsageClientAuth)
+		if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		os.Chtimes(certFile, mod, mod)
+		os.Chtimes(keyFile, mod, mod)
+	}
+
+	// without client certificate
+	_, err := Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool)))
+	if err == nil {
+		t.Errorf("Expected handshake error, got nil")
+	}
+
+	write("client-1", time.Now().Add(-time.Minute))
+	response, err := Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool), WithClientCert(certFile, keyFile)))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "client-1" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "client-1", string(response))
+	}
+
+	// rotate
+	write("client-2", time.Now())
+	response, err = Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool), WithClientCert(certFile, keyFile)))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "client-2" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "client-2", string(response))
+	}
+
+	// broken files in the middle of a rotation, keep the previous certificate
+	os.WriteFile(keyFile, []byte("broken"), 0600)
+	response, err = Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool), WithClientCert(certFile, keyFile)))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "client-2" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "client-2", string(response))
+	}
+}
+
+func TestClientCertSource(t *testing.T) {
+	ca := newTestCA(t)
+	server := newMTLSTestServer(t, ca)
+	defer server.Close()
+
+	certPEM, keyPEM := ca.issue(t, "source", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Call the function being tested
+	response, err := Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool), WithClientCertSource(func() (*tls.Certificate, error) {
+		return &cert, nil
+	})))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "source" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "source", string(response))
+	}
+}
+
+func TestClientCertKeepAlive(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	defer func() { certCheckInterval = interval }()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Create a test server which never asks for a client certificate
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	certPEM, keyPEM = ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	os.WriteFile(certFile, certPEM, 0600)
+	os.WriteFile(keyFile, keyPEM, 0600)
+
+	// the certificate is never loaded, the connection is kept alive
+	for i := 0; i < 3; i++ {
+		_, err := Do(NewOptions(WithURL(server.URL), WithRootCAs(ca.pool), WithClientCert(certFile, keyFile)))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Unexpected connections: %d", n)
+	}
+}
@@ -0,0 +1,184 @@
+package ray
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Coalescer share one in-flight call between identical idempotent requests, the other callers wait and receive a copy of its result,
+// errors included; only GET, HEAD and OPTIONS requests without body are coalesced, create it once and share it with WithCoalescer
+// the Authorization and Cookie headers are part of the default key, but credentials added by hooks or token sources are not,
+// share a coalescer between requests of the same identity
+type Coalescer struct {
+	// Headers request headers which are part of the default key, eg. Accept
+	Headers []string
+	// Key custom key of a request, built without body, auth and hooks, requests with an empty key are not coalesced
+	Key func(req *http.Request) string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall in-flight call
+type coalescedCall struct {
+	done    chan struct{}
+	resp    *Response
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// InFlight number of in-flight calls
+func (c *Coalescer) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.calls)
+}
+
+// do call fn, unless an identical request is in flight already, a nil coalescer calls fn
+// the shared call is detached from the caller which started it, it is canceled once every caller gave up
+func (c *Coalescer) do(opts Options, fn func(opts Options) (*Response, error)) (*Response, error) {
+	if c == nil || opts.Body != nil || (opts.Method != http.MethodGet && opts.Method != http.MethodHead && opts.Method != http.MethodOptions) {
+		return fn(opts)
+	}
+	key, err := c.key(&opts)
+	if err != nil || key == "" {
+		return fn(opts)
+	}
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		if c.calls == nil {
+			c.calls = map[string]*coalescedCall{}
+		}
+		var ctx context.Context
+		ctx, call = c.start(opts.context())
+		shared := opts
+		shared.Context = ctx
+		c.calls[key] = call
+		go c.run(key, call, func() (*Response, error) { return fn(shared) })
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp.copy(), call.err
+	case <-opts.context().Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody waits for the result anymore
+			call.cancel()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+		}
+		c.mu.Unlock()
+		return nil, errors.WithMessage(opts.context().Err(), "ray.coalesce.wait")
+	}
+}
+
+// start new call with a context which keeps the values of ctx but not its cancellation
+func (c *Coalescer) start(ctx context.Context) (context.Context, *coalescedCall) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return ctx, &coalescedCall{done: make(chan struct{}), cancel: cancel}
+}
+
+// run do the shared call and publish its result
+func (c *Coalescer) run(key string, call *coalescedCall, fn func() (*Response, error)) {
+	defer call.cancel()
+	resp, err := fn()
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	call.resp, call.err = resp, err
+	close(call.done)
+}
+
+// key key of the request, method, url with the normalized query and the selected headers by default
+func (c *Coalescer) key(opts *Options) (string, error) {
+	reqUrl, err := requestURL(opts)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(opts.Method, reqUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range opts.Header {
+		req.Header.Set(k, v)
+	}
+	if opts.ContentType != "" {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+	if c.Key != nil {
+		return c.Key(req), nil
+	}
+	u := *req.URL
+	u.RawQuery = u.Query().Encode()
+	u.Fragment = ""
+	var b strings.Builder
+	b.WriteString(req.Method + " " + u.String())
+	headers := append([]string(nil), c.Headers...)
+	sort.Strings(headers)
+	for _, h := range headers {
+		b.WriteString("\n" + http.CanonicalHeaderKey(h) + ": " + strings.Join(req.Header.Values(h), ","))
+	}
+	if credentials := credentialKey(req.Header); credentials != "" {
+		b.WriteString("\ncredentials: " + credentials)
+	}
+	return b.String(), nil
+}
+
+// credentialHeaders request headers carrying credentials, requests of different credentials never share a response
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// credentialKey digest of the credential headers, empty if there is none
+func credentialKey(header http.Header) string {
+	h := sha256.New()
+	found := false
+	for _, name := range credentialHeaders {
+		for _, v := range header.Values(name) {
+			h.Write([]byte(name + ": " + v + "\n"))
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// copy deep copy of the response, each caller gets its own
+func (r *Response) copy() *Response {
+	if r == nil {
+		return nil
+	}
+	cp := *r
+	cp.Header = r.Header.Clone()
+	cp.Body = append([]byte(nil), r.Body...)
+	if r.URL != nil {
+		u := *r.URL
+		cp.URL = &u
+	}
+	cp.Redirects = append([]*url.URL(nil), r.Redirects...)
+	return &cp
+}
+
+// WithCoalescer set coalescer of identical in-flight requests
+func WithCoalescer(c *Coalescer) OptionHandle {
+	return func(opt *Options) {
+		opt.Coalescer = c
+	}
+}
@@ -0,0 +1,170 @@
+package ray
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+func TestCoalescer(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/user").Delay(50*time.Millisecond).ReplyJSON(http.StatusOK, map[string]string{"name": "ray"})
+	coalescer := &Coalescer{Headers: []string{"Accept-Language"}}
+	client := NewClient(Config{RetryTimes: 1, Options: []OptionHandle{WithTransport(mock), WithCoalescer(coalescer)}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the order of the query params does not matter
+			query := "a=1&b=2"
+			if i%2 == 0 {
+				query = "b=2&a=1"
+			}
+			var user struct {
+				Name string `json:"name"`
+			}
+			err := client.GetJson(context.Background(), "http://api.test/user?"+query, &user)
+			if err != nil || user.Name != "ray" {
+				t.Errorf("Unexpected response: %v %+v", err, user)
+			}
+		}(i)
+	}
+	eventually(t, func() bool { return coalescer.InFlight() == 1 })
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodGet, "/user", 1)
+
+	// the selected headers are part of the key
+	mock.Reset()
+	for _, lang := range []string{"en", "zh"} {
+		wg.Add(1)
+		go func(lang string) {
+			defer wg.Done()
+			_, err := client.Do(client.NewOptions(WithURL("http://api.test/user"), WithHeader(map[string]string{"Accept-Language": lang})))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}(lang)
+	}
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodGet, "/user", 2)
+
+	// requests of different credentials never share a response
+	mock.Reset()
+	for _, header := range []map[string]string{{"Authorization": "Bearer alice"}, {"Authorization": "Bearer bob"}, {"Cookie": "session=carol"}} {
+		wg.Add(1)
+		go func(header map[string]string) {
+			defer wg.Done()
+			_, err := client.Do(client.NewOptions(WithURL("http://api.test/user"), WithHeader(header)))
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}(header)
+	}
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodGet, "/user", 3)
+}
+
+func TestCoalescerShared(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/fail").Delay(50*time.Millisecond).Reply(http.StatusInternalServerError, "fail")
+	mock.On(http.MethodGet, "/ok").Delay(50*time.Millisecond).Reply(http.StatusOK, "ok")
+	mock.On(http.MethodPost, "/ok").Delay(50*time.Millisecond).Reply(http.StatusOK, "ok")
+	coalescer := &Coalescer{}
+	opts := func(method string, url string, ohs ...OptionHandle) Options {
+		return NewOptions(append([]OptionHandle{WithURL(url), WithMethod(method), WithTransport(mock), WithCoalescer(coalescer), WithRetryTimes(0)}, ohs...)...)
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([][]byte, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var se *StatusError
+			_, err := Do(opts(http.MethodGet, "http://api.test/fail"))
+			if !errors.As(err, &se) || se.StatusCode != http.StatusInternalServerError {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			body, err := Do(opts(http.MethodGet, "http://api.test/ok"))
+			if err != nil || len(body) == 0 {
+				t.Errorf("Unexpected response: %v %q", err, body)
+				return
+			}
+			// each caller owns its copy
+			body[0] = byte('0' + i)
+			bodies[i] = body
+		}(i)
+	}
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodGet, "/fail", 1)
+	mock.AssertCallCount(t, http.MethodGet, "/ok", 1)
+	if string(bodies[0]) != "0k" || string(bodies[1]) != "1k" || string(bodies[2]) != "2k" {
+		t.Errorf("Unexpected bodies: %q", bodies)
+	}
+
+	// requests with a body are not coalesced
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Do(opts(http.MethodPost, "http://api.test/ok", WithBodyS("{}")))
+		}()
+	}
+	wg.Wait()
+	mock.AssertCallCount(t, http.MethodPost, "/ok", 2)
+
+	// a waiting caller gives up with its own context
+	go Do(opts(http.MethodGet, "http://api.test/ok"))
+	eventually(t, func() bool { return coalescer.InFlight() == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Do(opts(http.MethodGet, "http://api.test/ok", WithContext(ctx)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCoalescerLeaderGivesUp(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/ok").Delay(100*time.Millisecond).Reply(http.StatusOK, "ok")
+	coalescer := &Coalescer{}
+	opts := func(ctx context.Context) Options {
+		return NewOptions(WithURL("http://api.test/ok"), WithTransport(mock), WithCoalescer(coalescer), WithRetryTimes(0), WithContext(ctx))
+	}
+
+	// the caller which started the call gives up, the waiting caller still gets the result
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	leader := make(chan error, 1)
+	go func() {
+		_, err := Do(opts(ctx))
+		leader <- err
+	}()
+	eventually(t, func() bool { return coalescer.InFlight() == 1 })
+	body, err := Do(opts(context.Background()))
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	if err := <-leader; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/ok", 1)
+
+	// the call is canceled once every caller gave up
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	Do(opts(ctx))
+	if n := coalescer.InFlight(); n != 0 {
+		t.Errorf("Unexpected in-flight calls: %d", n)
+	}
+}
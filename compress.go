@@ -0,0 +1,103 @@
+package ray
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Encoder encode a request body with a content coding
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+// encoders registered content codings of request bodies
+var encoders = struct {
+	sync.RWMutex
+	m map[string]Encoder
+}{m: map[string]Encoder{}}
+
+func init() {
+	RegisterEncoder("gzip", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+	RegisterEncoder("deflate", func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	})
+	RegisterEncoder("br", func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriter(w), nil
+	})
+	RegisterEncoder("zstd", func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	})
+}
+
+// RegisterEncoder register or replace the encoder of a content coding
+func RegisterEncoder(encoding string, encoder Encoder) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[strings.ToLower(encoding)] = encoder
+}
+
+// compressBody compress the body once before the attempts, the compressed body is a bytes.Reader so retries can replay it
+// bodies smaller than RequestCompressionThreshold are sent as is
+func compressBody(opts *Options) error {
+	if opts.RequestCompression == "" || opts.Body == nil {
+		return nil
+	}
+	encoding := strings.ToLower(opts.RequestCompression)
+	opts.RequestCompression = ""
+	encoders.RLock()
+	encoder, ok := encoders.m[encoding]
+	encoders.RUnlock()
+	if !ok {
+		return errors.Errorf("compress, unsupported encoding,[encoding]%s", encoding)
+	}
+	_, err := opts.Body.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.WithMessage(err, "compress.body.seek")
+	}
+	raw, err := io.ReadAll(opts.Body)
+	if err != nil {
+		return errors.WithMessage(err, "compress.body.read")
+	}
+	if int64(len(raw)) < opts.RequestCompressionThreshold {
+		opts.Body = bytes.NewReader(raw)
+		return nil
+	}
+	var buf bytes.Buffer
+	w, err := encoder(&buf)
+	if err != nil {
+		return errors.WithMessage(err, "compress.encoder")
+	}
+	_, err = w.Write(raw)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return errors.WithMessage(err, "compress.write")
+	}
+	opts.Body = bytes.NewReader(buf.Bytes())
+	WithHeader(map[string]string{"Content-Encoding": encoding})(opts)
+	return nil
+}
+
+// WithRequestCompression compress the request body with a registered encoding, eg. gzip or zstd, and set Content-Encoding
+// add it to Config.Options of a client to compress the bodies of PostRaw and PostForm
+func WithRequestCompression(encoding string) OptionHandle {
+	return func(opt *Options) {
+		opt.RequestCompression = encoding
+	}
+}
+
+// WithRequestCompressionThreshold only compress request bodies of at least size bytes
+func WithRequestCompressionThreshold(size int64) OptionHandle {
+	return func(opt *Options) {
+		opt.RequestCompressionThreshold = size
+	}
+}
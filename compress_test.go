@@ -0,0 +1,123 @@
+package ray
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newDecompressTestServer echo the decoded request body with its Content-Encoding, the first failures requests fail
+func newDecompressTestServer(failures int32) *httptest.Server {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Header.Get("Content-Encoding") + ":"))
+		w.Write(b)
+	}))
+}
+
+func TestRequestCompression(t *testing.T) {
+	// the first attempt fails, the compressed body is replayed
+	server := newDecompressTestServer(1)
+	defer server.Close()
+
+	body := strings.Repeat(`{"event":"click"}`, 100)
+	for _, encoding := range []string{"gzip", "zstd"} {
+		response, err := DoRetry(NewOptions(WithURL(server.URL), WithMethod("POST"), WithBodyS(body), WithRequestCompression(encoding)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(response) != encoding+":"+body {
+			t.Errorf("Unexpected response body: %s", response)
+		}
+	}
+}
+
+func TestRequestCompressionThreshold(t *testing.T) {
+	server := newDecompressTestServer(0)
+	defer server.Close()
+
+	client := NewClient(Config{
+		Timeout:    time.Second,
+		RetryTimes: 1,
+		Options:    []OptionHandle{WithRequestCompression("gzip"), WithRequestCompressionThreshold(64)},
+	})
+
+	// small body
+	response, err := client.PostRaw(context.Background(), server.URL, map[string]string{"k": "v"})
+	if err != nil || string(response) != `:{"k":"v"}` {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+
+	// large body
+	large := strings.Repeat("v", 100)
+	response, err = client.PostRaw(context.Background(), server.URL, map[string]string{"k": large})
+	if err != nil || string(response) != `gzip:{"k":"`+large+`"}` {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+
+	// unknown encoding
+	_, err = Do(NewOptions(WithURL(server.URL), WithMethod("POST"), WithBodyS(large), WithRequestCompression("lz4")))
+	if err == nil {
+		t.Errorf("Expected unsupported encoding error, got nil")
+	}
+}
+
+func TestRequestCompressionLog(t *testing.T) {
+	opts := NewOptions(WithURL("http://api.test/"), WithMethod("POST"), WithBodyS(strings.Repeat("v", 100)), WithRequestCompression("gzip"))
+	if err := compressBody(&opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// capture the output of the logger
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := StdLogger(&opts, nil)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+
+	// the compressed body is summarized, not printed
+	if err != nil || !regexp.MustCompile(`body:\d+ bytes gzip encoded\n`).Match(out) {
+		t.Errorf("Unexpected log: %q, %v", out, err)
+	}
+}
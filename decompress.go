@@ -0,0 +1,178 @@
+package ray
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Decoder decode a response body of a content coding
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// decoders registered content codings, the order is the preference of Accept-Encoding
+var decoders = struct {
+	sync.RWMutex
+	m     map[string]Decoder
+	order []string
+}{m: map[string]Decoder{}}
+
+func init() {
+	RegisterDecoder("gzip", func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+	RegisterDecoder("deflate", decodeDeflate)
+	RegisterDecoder("br", func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(r)), nil
+	})
+	RegisterDecoder("zstd", func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	})
+}
+
+// RegisterDecoder register or replace the decoder of a content coding, it is offered in Accept-Encoding
+func RegisterDecoder(encoding string, decoder Decoder) {
+	encoding = strings.ToLower(encoding)
+	decoders.Lock()
+	defer decoders.Unlock()
+	if _, ok := decoders.m[encoding]; !ok {
+		decoders.order = append(decoders.order, encoding)
+	}
+	decoders.m[encoding] = decoder
+}
+
+// acceptEncoding Accept-Encoding of the registered decoders
+func acceptEncoding() string {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	return strings.Join(decoders.order, ", ")
+}
+
+// decodeDeflate deflate is zlib wrapped by the spec, but some servers send raw deflate
+func decodeDeflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodeBody transparently decode the body of resp by its Content-Encoding
+// bodies of unknown codings are returned as is, decoded bodies are limited to maxSize if it is positive
+// responses which never carry a body are left alone, whatever Content-Encoding they advertise
+func decodeBody(resp *http.Response, maxSize int64) {
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified ||
+		(resp.Request != nil && resp.Request.Method == http.MethodHead) {
+		return
+	}
+	encodings := strings.Split(strings.ToLower(resp.Header.Get("Content-Encoding")), ",")
+	chain := make([]Decoder, 0, len(encodings))
+	decoders.RLock()
+	// codings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.TrimSpace(encodings[i])
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		decoder, ok := decoders.m[encoding]
+		if !ok {
+			decoders.RUnlock()
+			return
+		}
+		chain = append(chain, decoder)
+	}
+	decoders.RUnlock()
+	if len(chain) == 0 {
+		return
+	}
+	resp.Body = &decodedBody{raw: resp.Body, chain: chain, remaining: maxSize, limited: maxSize > 0}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decodedBody decoding body, the decoders are created lazily so empty bodies do not fail
+type decodedBody struct {
+	raw       io.ReadCloser
+	chain     []Decoder
+	r         io.Reader
+	closers   []io.Closer
+	err       error
+	remaining int64
+	limited   bool
+}
+
+// Read implements io.Reader
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.r == nil {
+		var r io.Reader = b.raw
+		for _, decoder := range b.chain {
+			rc, err := decoder(r)
+			// a decoder which hits the end before its header was given an empty body
+			if err == io.EOF {
+				b.r = http.NoBody
+				break
+			}
+			if err != nil {
+				b.err = errors.WithMessage(err, "ray.decompress")
+				return 0, b.err
+			}
+			b.closers = append(b.closers, rc)
+			r = rc
+		}
+		if b.r == nil {
+			b.r = r
+		}
+	}
+	if b.limited && int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	if b.limited {
+		if int64(n) > b.remaining {
+			b.err = ErrDecompressedTooLarge
+			return int(b.remaining), b.err
+		}
+		b.remaining -= int64(n)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *decodedBody) Close() error {
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		b.closers[i].Close()
+	}
+	return b.raw.Close()
+}
+
+// WithMaxDecompressedSize limit the size of a decoded response body, ErrDecompressedTooLarge is returned beyond it
+func WithMaxDecompressedSize(size int64) OptionHandle {
+	return func(opt *Options) {
+		opt.MaxDecompressedSize = size
+	}
+}
+
+// WithoutDecompression ray does not negotiate nor decode content codings, net/http still handles gzip on its own unless Accept-Encoding is set
+func WithoutDecompression() OptionHandle {
+	return func(opt *Options) {
+		opt.DisableDecompression = true
+	}
+}
@@ -0,0 +1,196 @@
+package ray
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compressTestBody(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("Unexpected encoding: %s", encoding)
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+// newCompressTestServer respond body compressed with the encoding of the query
+func newCompressTestServer(t *testing.T, body []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Query().Get("enc")
+		header := encoding
+		if encoding == "raw-deflate" {
+			header = "deflate"
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), header) {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Encoding", header)
+		w.WriteHeader(http.StatusOK)
+		w.Write(compressTestBody(t, encoding, body))
+	}))
+}
+
+func TestDecompress(t *testing.T) {
+	body := []byte(strings.Repeat("Hello, World! ", 100))
+	server := newCompressTestServer(t, body)
+	defer server.Close()
+
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			response, err := Do(NewOptions(WithURL(server.URL), WithQuery("enc="+encoding)))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(response, body) {
+				t.Errorf("Unexpected response body: %q", response)
+			}
+		})
+	}
+
+	// negotiated by the user
+	response, err := Do(NewOptions(WithURL(server.URL), WithQuery("enc=gzip"), WithHeader(map[string]string{"Accept-Encoding": "gzip"})))
+	if err != nil || !bytes.Equal(response, body) {
+		t.Errorf("Unexpected response: %q, %v", response, err)
+	}
+
+	// stream
+	err = DoStream(NewOptions(WithURL(server.URL), WithQuery("enc=zstd")), func(r *bufio.Reader) error {
+		got, err := io.ReadAll(r)
+		if !bytes.Equal(got, body) {
+			t.Errorf("Unexpected stream body: %q", got)
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	body := bytes.Repeat([]byte{0}, 1<<20)
+	server := newCompressTestServer(t, body)
+	defer server.Close()
+
+	// Call the function being tested
+	_, err := Do(NewOptions(WithURL(server.URL), WithQuery("enc=gzip"), WithMaxDecompressedSize(1<<10)))
+
+	// Check for errors
+	if !errors.Is(err, ErrDecompressedTooLarge) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// exactly at the limit
+	response, err := Do(NewOptions(WithURL(server.URL), WithQuery("enc=br"), WithMaxDecompressedSize(1<<20)))
+	if err != nil || len(response) != 1<<20 {
+		t.Errorf("Unexpected response: %d bytes, %v", len(response), err)
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("x-upper", func(r io.Reader) (io.ReadCloser, error) {
+		b, err := io.ReadAll(r)
+		return io.NopCloser(bytes.NewReader(bytes.ToUpper(b))), err
+	})
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "x-upper") {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Encoding", "x-upper")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	// Call the function being tested
+	response, err := Do(NewOptions(WithURL(server.URL)))
+
+	// Check for errors
+	if err != nil || string(response) != "HELLO" {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+}
+
+func TestDecompressEmptyBody(t *testing.T) {
+	// Create a test server, every response advertises gzip whether it has a body or not
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/empty":
+			w.WriteHeader(http.StatusOK)
+		case "/item":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Etag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(compressTestBody(t, "gzip", []byte("v1")))
+		default:
+			w.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				w.Write(compressTestBody(t, "gzip", []byte("hello")))
+			}
+		}
+	}))
+	defer server.Close()
+
+	// head
+	resp, err := DoResponse(NewOptions(WithURL(server.URL), WithMethod(http.MethodHead)))
+	if err != nil || len(resp.Body) != 0 {
+		t.Errorf("Unexpected response: %+v, %v", resp, err)
+	}
+
+	// empty body
+	response, err := Do(NewOptions(WithURL(server.URL + "/empty")))
+	if err != nil || len(response) != 0 {
+		t.Errorf("Unexpected response: %q, %v", response, err)
+	}
+
+	// no content is judged by the status policy, not failed by the decoder
+	_, err = Do(NewOptions(WithURL(server.URL + "/nocontent")))
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNoContent {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// not modified, the cache revalidates with the etag
+	client := NewClient(Config{Options: []OptionHandle{WithCache(&Cache{})}})
+	for _, status := range []string{CacheMiss, CacheRevalidated} {
+		resp, err := client.DoResponse(client.NewOptions(WithURL(server.URL + "/item")))
+		if err != nil || string(resp.Body) != "v1" || resp.Header.Get(CacheStatusHeader) != status {
+			t.Errorf("Unexpected response: %+v, %v", resp, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package ray
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// StatusError returned when the response status code is not accepted by the status policy
+type StatusError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Error implements error
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("[code]%d,[body]\n%+s", e.StatusCode, string(e.Body))
+}
+
+// checkStatus status policy shared by Do and DoStream, only 200 is treated as success
+func checkStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+	}
+	return nil
+}
+
+// tokenRejectedError a 401 of a token which was already refreshed, it is not retried
+type tokenRejectedError struct {
+	error
+}
+
+// Unwrap the StatusError of the rejected token
+func (e tokenRejectedError) Unwrap() error {
+	return e.error
+}
+
+var (
+	// ErrStreamIdleTimeout returned by DoStream when no bytes arrive within Options.StreamIdleTimeout
+	ErrStreamIdleTimeout = errors.New("ray.stream.idle.timeout")
+	// ErrFirstByteTimeout returned by DoStream when the first byte does not arrive within Options.FirstByteTimeout
+	ErrFirstByteTimeout = errors.New("ray.stream.firstbyte.timeout")
+)
+
+// ErrDecompressedTooLarge returned when a decoded response body exceeds Options.MaxDecompressedSize
+var ErrDecompressedTooLarge = errors.New("ray.decompress.too.large")
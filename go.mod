@@ -3,6 +3,9 @@ module github.com/rumis/ray
 go 1.21.4
 
 require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/klauspost/compress v1.17.4
 	github.com/pkg/errors v0.9.1
 	github.com/rumis/querystring v1.0.1
+	golang.org/x/net v0.21.0
 )
@@ -0,0 +1,162 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaults of Hedging
+const (
+	defaultHedgeMaxRatio   = 0.1
+	defaultHedgeSamples    = 100
+	defaultHedgeMinSamples = 20
+)
+
+// Hedging send a second attempt of an idempotent request when the first one is slow, the first response wins and the other attempt is canceled
+// only GET, HEAD and OPTIONS requests without body are hedged, create it once per upstream and share it with WithHedging
+type Hedging struct {
+	// Delay wait before the hedged attempt, used until the latency percentile is known
+	Delay time.Duration
+	// Percentile latency percentile of the recent responses used as delay once MinSamples are known, eg. 0.95, disabled if 0
+	Percentile float64
+	// Samples recent latencies kept, 100 if 0
+	Samples int
+	// MinSamples latencies needed before Percentile applies, 20 if 0
+	MinSamples int
+	// MaxRatio max ratio of hedged requests, 0.1 if 0, so an incident does not double the load
+	MaxRatio float64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	requests  float64
+	hedges    float64
+}
+
+// hedgeResult result of an attempt
+type hedgeResult struct {
+	resp *Response
+	err  error
+}
+
+// hedge do the request, with a hedged attempt if Options.Hedging is set and the request is idempotent
+func (c *Client) hedge(opts Options) (*Response, error) {
+	h := opts.Hedging
+	if h == nil || opts.Body != nil || (opts.Method != http.MethodGet && opts.Method != http.MethodHead && opts.Method != http.MethodOptions) {
+		return c.do(opts)
+	}
+	ctx, cancel := context.WithCancel(opts.context())
+	// cancel the loser
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	attempt := func() {
+		o := opts
+		o.Context = ctx
+		start := time.Now()
+		resp, err := c.do(o)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{resp: resp, err: err}
+	}
+	go attempt()
+	pending := 1
+	h.start()
+	if delay := h.delay(); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case r := <-results:
+			return r.resp, r.err
+		case <-timer.C:
+			if h.allow() {
+				go attempt()
+				pending++
+			}
+		}
+	}
+	var r hedgeResult
+	for ; pending > 0; pending-- {
+		r = <-results
+		if r.err == nil {
+			return r.resp, nil
+		}
+	}
+	return r.resp, r.err
+}
+
+// delay delay of the hedged attempt, no attempt is hedged while it is 0
+func (h *Hedging) delay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	minSamples := h.MinSamples
+	if minSamples <= 0 {
+		minSamples = defaultHedgeMinSamples
+	}
+	if h.Percentile <= 0 || len(h.latencies) < minSamples {
+		return h.Delay
+	}
+	sorted := append([]time.Duration(nil), h.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(h.Percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// start count a hedgeable request
+func (h *Hedging) start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	// decay the counts, the ratio follows the recent requests
+	if h.requests >= 2*float64(h.samples()) {
+		h.requests /= 2
+		h.hedges /= 2
+	}
+}
+
+// allow whether the ratio allows one more hedged attempt, the attempt is counted if so
+func (h *Hedging) allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	maxRatio := h.MaxRatio
+	if maxRatio <= 0 {
+		maxRatio = defaultHedgeMaxRatio
+	}
+	if (h.hedges+1)/h.requests > maxRatio {
+		return false
+	}
+	h.hedges++
+	return true
+}
+
+// observe record the latency of a successful attempt
+func (h *Hedging) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < h.samples() {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % len(h.latencies)
+}
+
+func (h *Hedging) samples() int {
+	if h.Samples > 0 {
+		return h.Samples
+	}
+	return defaultHedgeSamples
+}
+
+// WithHedging set hedging of idempotent requests
+func WithHedging(h *Hedging) OptionHandle {
+	return func(opt *Options) {
+		opt.Hedging = h
+	}
+}
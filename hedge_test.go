@@ -0,0 +1,74 @@
+package ray
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+func TestHedging(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Body: []byte("slow"), Delay: time.Second}).Reply(http.StatusOK, "fast")
+	mock.On(http.MethodPost, "/item").ReplyWith(raytest.Response{StatusCode: http.StatusOK, Body: []byte("slow"), Delay: 50 * time.Millisecond})
+	hedging := &Hedging{Delay: 20 * time.Millisecond, MaxRatio: 1}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithHedging(hedging)}})
+
+	// the hedged attempt wins, the slow one is canceled
+	start := time.Now()
+	body, err := client.Do(client.NewOptions(WithURL("http://api.test/item")))
+	if err != nil || string(body) != "fast" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("Unexpected elapsed time: %v", elapsed)
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/item", 2)
+
+	// requests which are not idempotent are never hedged
+	body, err = client.Do(client.NewOptions(WithURL("http://api.test/item"), WithMethod(http.MethodPost)))
+	if err != nil || string(body) != "slow" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	mock.AssertCallCount(t, http.MethodPost, "/item", 1)
+}
+
+func TestHedgingRatio(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/item").Delay(20*time.Millisecond).Reply(http.StatusOK, "ok")
+	hedging := &Hedging{Delay: time.Millisecond, MaxRatio: 0.5}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithHedging(hedging)}})
+
+	for i := 0; i < 10; i++ {
+		body, err := client.DoRetry(client.NewOptions(WithURL("http://api.test/item")))
+		if err != nil || string(body) != "ok" {
+			t.Errorf("Unexpected response: %v %s", err, string(body))
+		}
+	}
+	// one hedged attempt every other request at most
+	if calls := len(mock.Calls()); calls != 15 {
+		t.Errorf("Unexpected calls: %d", calls)
+	}
+}
+
+func TestHedgingPercentile(t *testing.T) {
+	hedging := &Hedging{Delay: time.Second, Percentile: 0.9, MinSamples: 10, Samples: 20}
+	for i := 1; i <= 9; i++ {
+		hedging.observe(time.Duration(i) * time.Millisecond)
+	}
+	if delay := hedging.delay(); delay != time.Second {
+		t.Errorf("Unexpected delay before MinSamples: %v", delay)
+	}
+	hedging.observe(10 * time.Millisecond)
+	if delay := hedging.delay(); delay != 10*time.Millisecond {
+		t.Errorf("Unexpected delay: %v", delay)
+	}
+	// the oldest samples are replaced
+	for i := 0; i < 20; i++ {
+		hedging.observe(100 * time.Millisecond)
+	}
+	if delay := hedging.delay(); delay != 100*time.Millisecond || len(hedging.latencies) != 20 {
+		t.Errorf("Unexpected delay: %v", delay)
+	}
+}
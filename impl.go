@@ -18,14 +18,19 @@ import (
  * @date: 2023-12-11 10:58:01
  */
 func Get(ctx context.Context, url string, query ...interface{}) ([]byte, error) {
+	return defaultClient.Get(ctx, url, query...)
+}
+
+// Get request with GET method, using the config of the client
+func (c *Client) Get(ctx context.Context, url string, query ...interface{}) ([]byte, error) {
 	ohs := make([]OptionHandle, 0, 6)
 	// OptionHandle
 	err := optionHandleBuild(ctx, &ohs, url, query...)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "ray.request.get.option,[url]%+v,[query]%+v", url, query)
 	}
-	opt := NewOptions(ohs...)
-	buf, err := DoRetry(opt)
+	opt := c.NewOptions(ohs...)
+	buf, err := c.DoRetry(opt)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "ray.request.get.do,[url]%+v,[query]%+v", url, query)
 	}
@@ -44,7 +49,12 @@ func Get(ctx context.Context, url string, query ...interface{}) ([]byte, error)
  * @date: 2023-12-11 11:03:22
  */
 func GetJson(ctx context.Context, url string, data interface{}, query ...interface{}) error {
-	buf, err := Get(ctx, url, query...)
+	return defaultClient.GetJson(ctx, url, data, query...)
+}
+
+// GetJson request with GET method, return json, using the config of the client
+func (c *Client) GetJson(ctx context.Context, url string, data interface{}, query ...interface{}) error {
+	buf, err := c.Get(ctx, url, query...)
 	if err != nil {
 		return errors.WithMessagef(err, "ray.request.getjson.get,[url]%+v,[query]%+v", url, query)
 	}
@@ -66,6 +76,11 @@ func GetJson(ctx context.Context, url string, data interface{}, query ...interfa
  * @date: 2023-12-11 11:16:45
  */
 func PostForm(ctx context.Context, url string, body interface{}, query ...interface{}) ([]byte, error) {
+	return defaultClient.PostForm(ctx, url, body, query...)
+}
+
+// PostForm request with application/x-www-form-urlencoded, using the config of the client
+func (c *Client) PostForm(ctx context.Context, url string, body interface{}, query ...interface{}) ([]byte, error) {
 	ohs := make([]OptionHandle, 0, 8)
 	// OptionHandle
 	err := optionHandleBuild(ctx, &ohs, url, query...)
@@ -87,8 +102,8 @@ func PostForm(ctx context.Context, url string, body interface{}, query ...interf
 		ohs = append(ohs, WithContentType("application/x-www-form-urlencoded"))
 	}
 	// do
-	opt := NewOptions(ohs...)
-	buf, err := DoRetry(opt)
+	opt := c.NewOptions(ohs...)
+	buf, err := c.DoRetry(opt)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "ray.request.postform.do,[url]%+v,[params]%+v,[query]%+v", url, body, query)
 	}
@@ -97,6 +112,11 @@ func PostForm(ctx context.Context, url string, body interface{}, query ...interf
 
 // PostRaw request with application/json
 func PostRaw(ctx context.Context, url string, body interface{}, query ...interface{}) ([]byte, error) {
+	return defaultClient.PostRaw(ctx, url, body, query...)
+}
+
+// PostRaw request with application/json, using the config of the client
+func (c *Client) PostRaw(ctx context.Context, url string, body interface{}, query ...interface{}) ([]byte, error) {
 	ohs := make([]OptionHandle, 0, 8)
 	// OptionHandle
 	err := optionHandleBuild(ctx, &ohs, url, query...)
@@ -119,8 +139,8 @@ func PostRaw(ctx context.Context, url string, body interface{}, query ...interfa
 		ohs = append(ohs, WithContentType("application/json"))
 	}
 	// do
-	opt := NewOptions(ohs...)
-	buf, err := DoRetry(opt)
+	opt := c.NewOptions(ohs...)
+	buf, err := c.DoRetry(opt)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "ray.request.postraw.do,[url]%+v,[params]%+v,[query]%+v", url, body, query)
 	}
@@ -129,7 +149,12 @@ func PostRaw(ctx context.Context, url string, body interface{}, query ...interfa
 
 // PostFormJson request with application/x-www-form-urlencoded and return json
 func PostFormJson(ctx context.Context, url string, body interface{}, data interface{}, query ...interface{}) error {
-	buf, err := PostForm(ctx, url, body, query...)
+	return defaultClient.PostFormJson(ctx, url, body, data, query...)
+}
+
+// PostFormJson request with application/x-www-form-urlencoded and return json, using the config of the client
+func (c *Client) PostFormJson(ctx context.Context, url string, body interface{}, data interface{}, query ...interface{}) error {
+	buf, err := c.PostForm(ctx, url, body, query...)
 	if err != nil {
 		return errors.WithMessage(err, "ray.request.postformjson")
 	}
@@ -142,7 +167,12 @@ func PostFormJson(ctx context.Context, url string, body interface{}, data interf
 
 // PostRawJson request with application/json and return json
 func PostRawJson(ctx context.Context, url string, body interface{}, data interface{}, query ...interface{}) error {
-	buf, err := PostRaw(ctx, url, body, query...)
+	return defaultClient.PostRawJson(ctx, url, body, data, query...)
+}
+
+// PostRawJson request with application/json and return json, using the config of the client
+func (c *Client) PostRawJson(ctx context.Context, url string, body interface{}, data interface{}, query ...interface{}) error {
+	buf, err := c.PostRaw(ctx, url, body, query...)
 	if err != nil {
 		return errors.WithMessage(err, "ray.request.postraw")
 	}
@@ -166,6 +196,8 @@ func PostRawJson(ctx context.Context, url string, body interface{}, data interfa
  * @date: 2023-12-11 11:18:52
  */
 func optionHandleBuild(ctx context.Context, ohs *[]OptionHandle, url string, query ...interface{}) error {
+	// Context
+	*ohs = append(*ohs, WithContext(ctx))
 	// URL
 	*ohs = append(*ohs, WithURL(url))
 	// Query
@@ -2,6 +2,7 @@ package ray
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -172,3 +173,22 @@ func TestPostRawJson(t *testing.T) {
 		t.Errorf("Unexpected response body. Expected: %s, Got: %s", expectedBody, resp)
 	}
 }
+
+func TestGetContext(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Call the function being tested
+	_, err := Get(ctx, server.URL)
+
+	// Check for errors
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -3,22 +3,18 @@ package ray
 import (
 	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
-func init() {
-	defaultLogger = StdLogger
-}
-
 // LoggerHandle logger handle defined
 type LoggerHandle func(opt *Options, err error) error
 
-// default logger
-var defaultLogger LoggerHandle
-
-// SetGlobalLogger set global logger
+// SetGlobalLogger set logger of the default client
 func SetGlobalLogger(logger LoggerHandle) {
-	defaultLogger = logger
+	defaultClient.update(func(cfg *Config) {
+		cfg.Logger = logger
+	})
 }
 
 // StdLogger default logger
@@ -33,8 +29,11 @@ func StdLogger(opt *Options, err error) error {
 	if opt.RetryTimes != 0 {
 		logInfo += "retry:" + fmt.Sprintf("%d", opt.RetryTimes) + "\n"
 	}
-	if opt.Timeout != 0 {
-		logInfo += "timeout:" + fmt.Sprintf("%d", opt.Timeout) + "\n"
+	if opt.timeout() != 0 {
+		logInfo += "timeout:" + opt.timeout().String() + "\n"
+	}
+	if opt.Proxy != "" {
+		logInfo += "proxy:" + redactProxy(opt.Proxy) + "\n"
 	}
 	if opt.ContentType != "" {
 		logInfo += "content-type:" + opt.ContentType + "\n"
@@ -51,7 +50,12 @@ func StdLogger(opt *Options, err error) error {
 		if err != nil {
 			return err
 		}
-		logInfo += "body:" + string(b) + "\n"
+		// a compressed body is binary, only its size is logged
+		if encoding := headerValue(opt.Header, "Content-Encoding"); encoding != "" {
+			logInfo += "body:" + fmt.Sprintf("%d bytes %s encoded", len(b), encoding) + "\n"
+		} else {
+			logInfo += "body:" + string(b) + "\n"
+		}
 	}
 	logInfo += "time:" + time.Now().Format(time.DateTime)
 
@@ -59,3 +63,18 @@ func StdLogger(opt *Options, err error) error {
 
 	return nil
 }
+
+// headerValue value of the header name in header, case-insensitive
+func headerValue(header map[string]string, name string) string {
+	for k, v := range header {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// warn print a warning of ray itself
+func warn(msg string) {
+	fmt.Println("ray warning \n" + msg + "\ntime:" + time.Now().Format(time.DateTime))
+}
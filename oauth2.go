@@ -0,0 +1,119 @@
+package ray
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how ClientCredentials sends the client id and secret
+const (
+	// AuthStyleInHeader http basic auth, RFC 6749 section 2.3.1
+	AuthStyleInHeader = iota
+	// AuthStyleInParams client_id and client_secret form params
+	AuthStyleInParams
+)
+
+// ClientCredentials OAuth2 client credentials token source
+// tokens are fetched with ray, cached until shortly before expires_in and concurrent refreshes are de-duplicated,
+// create it once and plug it into requests with WithTokenSource
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams additional form params of the token request, eg. audience
+	EndpointParams map[string]string
+	// AuthStyle AuthStyleInHeader or AuthStyleInParams
+	AuthStyle int
+	// Client client used to fetch tokens, the default client if nil
+	Client *Client
+
+	once  sync.Once
+	cache *TokenCache
+}
+
+// tokenResponse successful response of the token endpoint
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// Token implements TokenSource
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	c.once.Do(func() {
+		c.cache = NewTokenCache(TokenSourceFunc(c.fetch))
+	})
+	return c.cache.Token(ctx)
+}
+
+// Invalidate drop the cached token, see TokenCache.Invalidate
+func (c *ClientCredentials) Invalidate(accessToken string) {
+	c.once.Do(func() {
+		c.cache = NewTokenCache(TokenSourceFunc(c.fetch))
+	})
+	c.cache.Invalidate(accessToken)
+}
+
+// fetch request a new token from the token endpoint
+func (c *ClientCredentials) fetch(ctx context.Context) (*Token, error) {
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for k, v := range c.EndpointParams {
+		form.Set(k, v)
+	}
+	header := map[string]string{"Accept": "application/json"}
+	if c.AuthStyle == AuthStyleInParams {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	} else {
+		credentials := url.QueryEscape(c.ClientID) + ":" + url.QueryEscape(c.ClientSecret)
+		header["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	opts := client.NewOptions(
+		WithContext(ctx),
+		WithURL(c.TokenURL),
+		WithMethod("POST"),
+		WithBodyS(form.Encode()),
+		WithContentType("application/x-www-form-urlencoded"),
+		WithHeader(header),
+		// the request carries the client secret, never log it
+		WithLogger(func(opt *Options, err error) error { return nil }),
+	)
+	var resp tokenResponse
+	err := client.DoJSON(opts, &resp)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ray.oauth2.clientcredentials.fetch,[url]%s", c.TokenURL)
+	}
+	if resp.AccessToken == "" {
+		return nil, errors.Errorf("ray.oauth2.clientcredentials.fetch, empty access token,[url]%s", c.TokenURL)
+	}
+	token := &Token{AccessToken: resp.AccessToken, TokenType: resp.TokenType}
+	if strings.EqualFold(token.TokenType, "bearer") {
+		token.TokenType = "Bearer"
+	}
+	if resp.ExpiresIn != "" {
+		seconds, err := resp.ExpiresIn.Int64()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "ray.oauth2.clientcredentials.expires_in,[value]%s", resp.ExpiresIn)
+		}
+		if seconds > 0 {
+			token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return token, nil
+}
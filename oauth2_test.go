@@ -0,0 +1,105 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTokenTestServer start a client credentials token server, the issued tokens expire in expiresIn seconds
+func newTokenTestServer(t *testing.T, expiresIn string, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		id, secret, ok := r.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if r.PostFormValue("client_id") != "" {
+			id, secret, ok = r.PostFormValue("client_id"), r.PostFormValue("client_secret"), true
+		}
+		if !ok || id != "client" || secret != "s3cr&t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token":"tok","token_type":"bearer","expires_in":` + expiresIn + `}`))
+	}))
+}
+
+func TestClientCredentials(t *testing.T) {
+	var tokenCalls int32
+	tokenServer := newTokenTestServer(t, "3600", &tokenCalls)
+	defer tokenServer.Close()
+	server := newAuthTestServer()
+	defer server.Close()
+
+	for _, style := range []int{AuthStyleInHeader, AuthStyleInParams} {
+		atomic.StoreInt32(&tokenCalls, 0)
+		cc := &ClientCredentials{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cr&t",
+			Scopes:       []string{"read", "write"},
+			AuthStyle:    style,
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response, err := Do(NewOptions(WithURL(server.URL), WithTokenSource(cc)))
+				if err != nil || string(response) != "Bearer tok||" {
+					t.Errorf("Unexpected response: %s, %v", response, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&tokenCalls); n != 1 {
+			t.Errorf("Unexpected token requests. Expected: %d, Got: %d", 1, n)
+		}
+	}
+}
+
+func TestClientCredentialsExpiry(t *testing.T) {
+	var tokenCalls int32
+	// tokens living less than tokenExpiryDelta are reused until shortly before they expire
+	tokenServer := newTokenTestServer(t, `"5"`, &tokenCalls)
+	defer tokenServer.Close()
+
+	cc := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "s3cr&t", Scopes: []string{"read", "write"}}
+	for i := 0; i < 3; i++ {
+		token, err := cc.Token(context.Background())
+		if err != nil || token.AccessToken != "tok" || token.Expiry.IsZero() {
+			t.Errorf("Unexpected token: %+v, %v", token, err)
+		}
+	}
+	if n := atomic.LoadInt32(&tokenCalls); n != 1 {
+		t.Errorf("Unexpected token requests. Expected: %d, Got: %d", 1, n)
+	}
+}
+
+func TestClientCredentialsError(t *testing.T) {
+	var tokenCalls int32
+	tokenServer := newTokenTestServer(t, "3600", &tokenCalls)
+	defer tokenServer.Close()
+
+	cc := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "wrong", Client: NewClient(Config{Timeout: time.Second})}
+	_, err := cc.Token(context.Background())
+	if err == nil {
+		t.Errorf("Expected invalid client error, got nil")
+	}
+}
@@ -0,0 +1,161 @@
+package ray
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hasProxyConfig whether a proxy option or the default proxy was set
+func (o *Options) hasProxyConfig() bool {
+	return o.defaults().Proxy != "" || o.Proxy != "" || o.ProxyFunc != nil || len(o.NoProxy) > 0 || o.ProxyUser != ""
+}
+
+// proxyFunc build the proxy selector of the transport
+// the order is NoProxy, ProxyFunc, Options.Proxy, default proxy and at last HTTP_PROXY/HTTPS_PROXY/NO_PROXY of the environment
+// supported schemes are http, https, socks5 and socks5h, the credentials of ProxyUser are added when the url has none
+func proxyFunc(opts *Options) (func(*http.Request) (*url.URL, error), error) {
+	selector := opts.ProxyFunc
+	if selector == nil {
+		raw := opts.defaults().Proxy
+		if opts.Proxy != "" {
+			raw = opts.Proxy
+		}
+		if raw != "" {
+			up, err := parseProxy(raw)
+			if err != nil {
+				return nil, err
+			}
+			selector = http.ProxyURL(up)
+		} else {
+			selector = http.ProxyFromEnvironment
+		}
+	}
+	noProxy := opts.NoProxy
+	user, password := opts.ProxyUser, opts.ProxyPassword
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		up, err := selector(req)
+		if err != nil || up == nil {
+			return up, err
+		}
+		if user != "" && up.User == nil {
+			withUser := *up
+			withUser.User = url.UserPassword(user, password)
+			up = &withUser
+		}
+		return up, nil
+	}, nil
+}
+
+// parseProxy parse the proxy url, the error never contains the credentials of the url
+func parseProxy(raw string) (*url.URL, error) {
+	up, err := url.Parse(raw)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return nil, errors.WithMessage(err, "proxy.parse")
+	}
+	switch up.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.Errorf("proxy.parse, unsupported scheme,[scheme]%s", up.Scheme)
+	}
+	return up, nil
+}
+
+// redactProxy proxy url with the password replaced, used for logging
+func redactProxy(raw string) string {
+	up, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid proxy url>"
+	}
+	return up.Redacted()
+}
+
+// bypassProxy match the destination against a NO_PROXY style list
+// "*" matches all, "example.com" matches the domain and its subdomains, ".example.com" only the subdomains,
+// an ip or a cidr matches the ip address, and an optional ":port" restricts the match to the port
+func bypassProxy(u *url.URL, noProxy []string) bool {
+	if len(noProxy) == 0 {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+	for _, pattern := range noProxy {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		patternHost, patternPort := pattern, ""
+		if h, p, err := net.SplitHostPort(pattern); err == nil {
+			patternHost, patternPort = h, p
+		}
+		if patternPort != "" && patternPort != port {
+			continue
+		}
+		if pip := net.ParseIP(strings.Trim(patternHost, "[]")); pip != nil {
+			if ip != nil && pip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(patternHost, ".") {
+			if strings.HasSuffix(host, patternHost) {
+				return true
+			}
+			continue
+		}
+		if host == patternHost || strings.HasSuffix(host, "."+patternHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithProxyFunc set proxy selector per destination, returning a nil url means no proxy
+func WithProxyFunc(fn func(req *http.Request) (*url.URL, error)) OptionHandle {
+	// the requests of the option share a transport, create the option once to keep the connections alive
+	id := new(byte)
+	return func(opt *Options) {
+		opt.ProxyFunc = fn
+		opt.proxyFuncID = id
+	}
+}
+
+// WithProxyAuth set proxy credentials, sent as Proxy-Authorization for http proxies and as socks5 auth
+func WithProxyAuth(user string, password string) OptionHandle {
+	return func(opt *Options) {
+		opt.ProxyUser = user
+		opt.ProxyPassword = password
+	}
+}
+
+// WithNoProxy set destinations which bypass the proxy, see bypassProxy for the patterns
+func WithNoProxy(hosts ...string) OptionHandle {
+	return func(opt *Options) {
+		opt.NoProxy = append(opt.NoProxy[:len(opt.NoProxy):len(opt.NoProxy)], hosts...)
+	}
+}
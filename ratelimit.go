@@ -0,0 +1,262 @@
+package ray
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRateLimited matches the RateLimitError of a request rejected by a fail fast RateLimiter, never retried
+var ErrRateLimited = errors.New("ray.ratelimit.exceeded")
+
+// RateLimitError returned by a fail fast RateLimiter when the bucket of the request is empty
+type RateLimitError struct {
+	Key string
+	// RetryAfter time until a token is available
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("ray.ratelimit.exceeded,[key]%s,[retryafter]%s", e.Key, e.RetryAfter)
+}
+
+// Is errors.Is(err, ErrRateLimited) holds
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimit token bucket of Rate requests per second, up to Burst at once
+type RateLimit struct {
+	Rate float64
+	// Burst size of the bucket, 1 if 0
+	Burst int
+}
+
+// RateRoute limit of the requests matching Pattern, the host and the path of the url matched with path.Match, eg. api.example.com/users/*
+type RateRoute struct {
+	Pattern string
+	RateLimit
+}
+
+// RateLimiter client side token bucket rate limiter
+// the bucket of a request is the first matching route, then the limit of its host, then the default limit per host,
+// all requests of a custom Key share the default limit; create it once and share it with WithRateLimiter
+type RateLimiter struct {
+	// RateLimit default limit per host, no limit if Rate is 0
+	RateLimit
+	// Hosts limits per host
+	Hosts map[string]RateLimit
+	// Routes limits per route, tried in order
+	Routes []RateRoute
+	// Key custom bucket key of a request, takes precedence over Routes and Hosts
+	Key func(req *http.Request) string
+	// FailFast fail with a RateLimitError instead of waiting for a token
+	FailFast bool
+	// Adaptive follow the X-RateLimit-Remaining/X-RateLimit-Reset and the Retry-After of 429 responses
+	Adaptive bool
+	// Now clock, time.Now if nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket token bucket of a key, quota is the remaining quota announced by the server until reset
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+	quota  int
+	reset  time.Time
+}
+
+// wait take a token for the request, waiting until one is available unless FailFast is set
+// observe must be called with the response to adapt the limit, a nil limiter does not limit
+func (l *RateLimiter) wait(req *http.Request) (observe func(resp *http.Response), err error) {
+	if l == nil {
+		return func(resp *http.Response) {}, nil
+	}
+	key, limit, ok := l.limit(req)
+	if !ok {
+		return func(resp *http.Response) {}, nil
+	}
+	l.mu.Lock()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.burst()), last: l.now()}
+		l.buckets[key] = b
+	}
+	delay := b.take(l.now(), l.FailFast)
+	l.mu.Unlock()
+
+	observe = func(resp *http.Response) {
+		if l.Adaptive && resp != nil {
+			l.mu.Lock()
+			b.observe(l.now(), resp)
+			l.mu.Unlock()
+		}
+	}
+	if delay <= 0 {
+		return observe, nil
+	}
+	if l.FailFast {
+		return nil, &RateLimitError{Key: key, RetryAfter: delay}
+	}
+	ctx := req.Context()
+	// do not wait for a token which comes after the deadline
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		l.mu.Lock()
+		b.cancel()
+		l.mu.Unlock()
+		return nil, errors.WithMessagef(context.DeadlineExceeded, "ray.ratelimit.wait,[key]%s", key)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return observe, nil
+	case <-ctx.Done():
+		// give the reserved token back
+		l.mu.Lock()
+		b.cancel()
+		l.mu.Unlock()
+		return nil, errors.WithMessagef(ctx.Err(), "ray.ratelimit.wait,[key]%s", key)
+	}
+}
+
+// limit bucket key and limit of the request, ok is false if the request is not limited
+func (l *RateLimiter) limit(req *http.Request) (key string, limit RateLimit, ok bool) {
+	if l.Key != nil {
+		return l.Key(req), l.RateLimit, l.Rate > 0 || l.Adaptive
+	}
+	for _, route := range l.Routes {
+		if matched, _ := path.Match(route.Pattern, req.URL.Host+req.URL.Path); matched {
+			return route.Pattern, route.RateLimit, true
+		}
+	}
+	if limit, ok := l.Hosts[req.URL.Host]; ok {
+		return req.URL.Host, limit, true
+	}
+	return req.URL.Host, l.RateLimit, l.Rate > 0 || l.Adaptive
+}
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// take take a token, return the time to wait for it
+// a token is reserved unless failFast is set and the wait is not zero
+func (b *bucket) take(now time.Time, failFast bool) time.Duration {
+	var wait time.Duration
+	// the quota announced by the server
+	if !b.reset.IsZero() {
+		if now.Before(b.reset) {
+			if b.quota <= 0 {
+				wait = b.reset.Sub(now)
+			}
+		} else {
+			b.reset = time.Time{}
+		}
+	}
+	if b.limit.Rate > 0 {
+		b.tokens = math.Min(float64(b.limit.burst()), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+		b.last = now
+		if b.tokens < 1 {
+			wait = maxDuration(wait, time.Duration((1-b.tokens)/b.limit.Rate*float64(time.Second)))
+		}
+	}
+	if failFast && wait > 0 {
+		return wait
+	}
+	if b.limit.Rate > 0 {
+		b.tokens--
+	}
+	if !b.reset.IsZero() {
+		b.quota--
+	}
+	return wait
+}
+
+// cancel give back a token taken by take
+func (b *bucket) cancel() {
+	if b.limit.Rate > 0 {
+		b.tokens++
+	}
+	if !b.reset.IsZero() {
+		b.quota++
+	}
+}
+
+// observe adapt the bucket to the quota announced by the response
+func (b *bucket) observe(now time.Time, resp *http.Response) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if after, ok := parseRetryAfter(now, resp.Header.Get("Retry-After")); ok {
+			b.quota, b.reset = 0, after
+			return
+		}
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	// either the unix time or the seconds until the reset
+	if reset > 1e9 {
+		b.reset = time.Unix(reset, 0)
+	} else {
+		b.reset = now.Add(time.Duration(reset) * time.Second)
+	}
+	b.quota = remaining
+}
+
+func (r RateLimit) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return 1
+}
+
+// parseRetryAfter parse the Retry-After header, seconds or an http date
+func parseRetryAfter(now time.Time, value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// WithRateLimiter set rate limiter, share one limiter between the requests to the same upstreams
+func WithRateLimiter(l *RateLimiter) OptionHandle {
+	return func(opt *Options) {
+		opt.RateLimiter = l
+	}
+}
@@ -0,0 +1,178 @@
+package ray
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/").Reply(http.StatusOK, "ok")
+	limiter := &RateLimiter{RateLimit: RateLimit{Rate: 20, Burst: 2}}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithRateLimiter(limiter)}})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := client.Do(client.NewOptions(WithURL("http://api.test/")))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	// the burst goes through at once, then one request every 50ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Unexpected elapsed time: %v", elapsed)
+	}
+
+	// the wait respects the deadline of the request
+	start = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	limiter.RateLimit = RateLimit{Rate: 0.1}
+	limiter.buckets = nil
+	client.Do(client.NewOptions(WithURL("http://api.test/")))
+	_, err := client.Do(client.NewOptions(WithURL("http://api.test/"), WithContext(ctx)))
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Unexpected error: %v %v", err, time.Since(start))
+	}
+}
+
+func TestRateLimiterFailFast(t *testing.T) {
+	mock := raytest.New()
+	mock.On("", "/*").Reply(http.StatusOK, "ok")
+	mock.On("", "/*/*").Reply(http.StatusOK, "ok")
+	clock := &testClock{now: time.Now()}
+	limiter := &RateLimiter{
+		RateLimit: RateLimit{Rate: 100},
+		Hosts:     map[string]RateLimit{"slow.test": {Rate: 1}},
+		Routes:    []RateRoute{{Pattern: "api.test/search/*", RateLimit: RateLimit{Rate: 0.5}}},
+		FailFast:  true,
+		Now:       clock.Now,
+	}
+	do := func(url string) error {
+		_, err := Do(NewOptions(WithURL(url), WithTransport(mock), WithRateLimiter(limiter), WithRetryTimes(3)))
+		return err
+	}
+
+	if err := do("http://api.test/search/a"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// the route bucket is shared by the route only
+	err := do("http://api.test/search/b")
+	var rle *RateLimitError
+	if !errors.As(err, &rle) || !errors.Is(err, ErrRateLimited) || rle.Key != "api.test/search/*" || rle.RetryAfter != 2*time.Second {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := do("http://api.test/items"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := do("http://slow.test/a"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := do("http://slow.test/b"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// rejected requests are not retried
+	if len(mock.Calls()) != 3 {
+		t.Errorf("Unexpected calls: %d", len(mock.Calls()))
+	}
+
+	clock.Add(time.Second)
+	if err := do("http://slow.test/b"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := do("http://api.test/search/b"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRateLimiterAdaptive(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/quota").ReplyWith(raytest.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Ratelimit-Remaining": []string{"1"}, "X-Ratelimit-Reset": []string{"60"}},
+	}).ReplyWith(raytest.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"60"}},
+	}).Reply(http.StatusOK, "ok")
+	mock.On(http.MethodGet, "/busy").ReplyWith(raytest.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"5"}},
+	})
+	clock := &testClock{now: time.Now()}
+	limiter := &RateLimiter{
+		Key:      func(req *http.Request) string { return req.URL.Path },
+		Adaptive: true,
+		FailFast: true,
+		Now:      clock.Now,
+	}
+	do := func(url string) error {
+		_, err := Do(NewOptions(WithURL(url), WithTransport(mock), WithRateLimiter(limiter), WithRetryTimes(0)))
+		return err
+	}
+
+	// the server announces one more request in the next 60s
+	do("http://api.test/quota")
+	if err := do("http://api.test/quota"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var rle *RateLimitError
+	if err := do("http://api.test/quota"); !errors.As(err, &rle) || rle.RetryAfter != time.Minute {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	clock.Add(time.Minute)
+	if err := do("http://api.test/quota"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// a 429 pauses the key for Retry-After
+	var se *StatusError
+	if err := do("http://api.test/busy"); !errors.As(err, &se) || se.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := do("http://api.test/busy"); !errors.As(err, &rle) || rle.RetryAfter != 5*time.Second {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRateLimiterRejectedByOtherLayers(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/down").Reply(http.StatusServiceUnavailable, "down")
+	clock := &testClock{now: time.Now()}
+	limiter := &RateLimiter{RateLimit: RateLimit{Rate: 1, Burst: 2}, FailFast: true, Now: clock.Now}
+	breaker := &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute}
+	bulkhead := &Bulkhead{MaxConcurrent: 1}
+	client := NewClient(Config{Options: []OptionHandle{WithTransport(mock), WithRateLimiter(limiter), WithCircuitBreaker(breaker), WithBulkhead(bulkhead), WithLogger(func(opt *Options, err error) error { return nil })}})
+	do := func(url string) error {
+		_, err := client.Do(client.NewOptions(WithURL(url)))
+		return err
+	}
+
+	// the failure opens the circuit of down.test, the open circuit fails fast without a token
+	do("http://down.test/down")
+	for i := 0; i < 3; i++ {
+		if err := do("http://down.test/down"); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	// the bulkhead of full.test is full, its rejections take no token either
+	req, _ := http.NewRequest(http.MethodGet, "http://full.test/", nil)
+	release, _ := bulkhead.acquire(req)
+	defer release()
+	for i := 0; i < 3; i++ {
+		if err := do("http://full.test/down"); !errors.Is(err, ErrBulkheadFull) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/down", 1)
+	if b := limiter.buckets["down.test"]; b == nil || b.tokens != 1 {
+		t.Errorf("Unexpected bucket of down.test: %+v", b)
+	}
+	if b := limiter.buckets["full.test"]; b != nil {
+		t.Errorf("Unexpected bucket of full.test: %+v", b)
+	}
+}
@@ -0,0 +1,60 @@
+package raytest
+
+import (
+	"path"
+	"testing"
+)
+
+// CallCount number of recorded requests with the method and a path matching the pattern, method "" matches any
+func (m *Mock) CallCount(method string, pattern string) int {
+	n := 0
+	for _, c := range m.Calls() {
+		if method != "" && method != c.Method {
+			continue
+		}
+		if ok, _ := path.Match(pattern, c.URL.Path); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// AssertCalled report an error unless the request was recorded at least once
+func (m *Mock) AssertCalled(t testing.TB, method string, pattern string) {
+	t.Helper()
+	if m.CallCount(method, pattern) == 0 {
+		t.Errorf("Unexpected calls: %s %s was not called", method, pattern)
+	}
+}
+
+// AssertNotCalled report an error if the request was recorded
+func (m *Mock) AssertNotCalled(t testing.TB, method string, pattern string) {
+	t.Helper()
+	if n := m.CallCount(method, pattern); n != 0 {
+		t.Errorf("Unexpected calls: %s %s was called %d times", method, pattern, n)
+	}
+}
+
+// AssertCallCount report an error unless the request was recorded exactly n times
+func (m *Mock) AssertCallCount(t testing.TB, method string, pattern string, n int) {
+	t.Helper()
+	if got := m.CallCount(method, pattern); got != n {
+		t.Errorf("Unexpected calls: %s %s was called %d times, expected %d", method, pattern, got, n)
+	}
+}
+
+// AssertExpectations report an error for every route never called and every request matching no route
+func (m *Mock) AssertExpectations(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	routes := append([]*Route(nil), m.routes...)
+	m.mu.Unlock()
+	for _, r := range routes {
+		if r.CallCount() == 0 {
+			t.Errorf("Unexpected calls: route %s %s was not called", r.method, r.path)
+		}
+	}
+	for _, c := range m.Unmatched() {
+		t.Errorf("Unexpected calls: no route for %s %s", c.Method, c.URL)
+	}
+}
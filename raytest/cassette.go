@@ -0,0 +1,523 @@
+package raytest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// RecordEnv environment variable which switches Cassette to record mode
+const RecordEnv = "RAYTEST_RECORD"
+
+// Redacted replacement of the redacted values
+const Redacted = "REDACTED"
+
+// ErrUnmatched returned by a strict Recorder for a request which is not in the cassette
+var ErrUnmatched = errors.New("raytest.cassette.unmatched")
+
+// Mode mode of a Recorder
+type Mode int
+
+const (
+	// ModeReplay answer from the cassette
+	ModeReplay Mode = iota
+	// ModeRecord do every request with the real transport and record it, the cassette is overwritten
+	ModeRecord
+)
+
+// RecordedRequest request of an interaction
+type RecordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// RecordedResponse response of an interaction
+type RecordedResponse struct {
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// Interaction request/response pair, one line of the cassette
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Recorder http.RoundTripper which records the interactions into a cassette and replays them offline
+// requests are matched on method, url, normalized query and body
+// in replay mode an unmatched request is done with the real transport and recorded, unless Strict is set
+type Recorder struct {
+	// Transport real transport, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// Strict fail unmatched requests with ErrUnmatched in replay mode, nothing goes to the network
+	Strict bool
+	// RedactHeaders headers of requests and responses replaced before writing
+	RedactHeaders []string
+	// RedactQuery query parameters replaced before writing
+	RedactQuery []string
+	// RedactFields fields of json and form bodies of requests and responses replaced before writing, at any depth, case-insensitive
+	RedactFields []string
+	// Redact custom redaction called before writing, after the headers, the query and the fields are redacted
+	// incoming requests are redacted the same way before matching, then Redact is called with an empty response
+	Redact func(i *Interaction)
+
+	file string
+	mode Mode
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	unmatched    []string
+}
+
+// NewRecorder new recorder of the cassette file, the cassette is loaded in replay mode
+func NewRecorder(file string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+		RedactFields:  []string{"password", "client_secret", "access_token", "refresh_token", "id_token"},
+		file:          file,
+		mode:          mode,
+	}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, errors.WithMessagef(err, "raytest.cassette.open,[file]%s", file)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var i Interaction
+		err = json.Unmarshal(line, &i)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "raytest.cassette.unmarshal,[file]%s", file)
+		}
+		r.interactions = append(r.interactions, i)
+		r.used = append(r.used, false)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.WithMessagef(err, "raytest.cassette.read,[file]%s", file)
+	}
+	return r, nil
+}
+
+// Cassette recorder for a test, strict replay unless RAYTEST_RECORD is set, the cassette is saved when the test ends
+func Cassette(t testing.TB, file string) *Recorder {
+	t.Helper()
+	mode := ModeReplay
+	if os.Getenv(RecordEnv) != "" {
+		mode = ModeRecord
+	}
+	r, err := NewRecorder(file, mode)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	r.Strict = mode == ModeReplay
+	t.Cleanup(func() {
+		if err := r.Save(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+	return r
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.WithMessage(err, "raytest.cassette.request.body.readall")
+		}
+	}
+	if r.mode == ModeReplay {
+		if i, ok := r.match(req, body); ok {
+			return i.Response.response(req), nil
+		}
+		if r.Strict {
+			r.mu.Lock()
+			r.unmatched = append(r.unmatched, req.Method+" "+req.URL.String())
+			r.mu.Unlock()
+			return nil, errors.WithMessagef(ErrUnmatched, "[request]%s %s", req.Method, req.URL.String())
+		}
+	}
+	return r.record(req, body)
+}
+
+// Save write the cassette, does nothing in strict replay mode
+func (r *Recorder) Save() error {
+	if r.mode == ModeReplay && r.Strict {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, i := range r.Interactions() {
+		line, err := json.Marshal(i)
+		if err != nil {
+			return errors.WithMessage(err, "raytest.cassette.save.marshal")
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := r.file + ".tmp"
+	err := os.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return errors.WithMessagef(err, "raytest.cassette.save.write,[file]%s", r.file)
+	}
+	err = os.Rename(tmp, r.file)
+	if err != nil {
+		return errors.WithMessagef(err, "raytest.cassette.save.rename,[file]%s", r.file)
+	}
+	return nil
+}
+
+// Interactions interactions of the cassette, redacted
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Unmatched requests rejected by a strict recorder
+func (r *Recorder) Unmatched() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.unmatched...)
+}
+
+// record do the request with the real transport and record the redacted interaction
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	out := req.Clone(req.Context())
+	if req.Body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.WithMessage(err, "raytest.cassette.response.body.readall")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	i := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    r.redactURL(req.URL),
+			Header: req.Header.Clone(),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+		},
+	}
+	// bodies are stored decoded so they can be redacted and matched
+	i.Request.Body, i.Request.BodyEncoding = encodeBody(decodeContent(i.Request.Header, body))
+	i.Response.Body, i.Response.BodyEncoding = encodeBody(decodeContent(i.Response.Header, respBody))
+	r.redact(&i)
+	r.mu.Lock()
+	r.interactions = append(r.interactions, i)
+	r.used = append(r.used, true)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// match first unused interaction matching the request, the last used one if all matching interactions were used
+func (r *Recorder) match(req *http.Request, body []byte) (Interaction, bool) {
+	redacted := r.redactedRequest(req, body)
+	b, err := decodeBody(redacted.Body, redacted.BodyEncoding)
+	if err != nil {
+		return Interaction{}, false
+	}
+	key := matchKey(redacted.Method, redacted.URL, redacted.Header.Get("Content-Type"), b)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := -1
+	for n, i := range r.interactions {
+		b, err := decodeBody(i.Request.Body, i.Request.BodyEncoding)
+		if err != nil || matchKey(i.Request.Method, i.Request.URL, i.Request.Header.Get("Content-Type"), b) != key {
+			continue
+		}
+		if !r.used[n] {
+			r.used[n] = true
+			return i, true
+		}
+		last = n
+	}
+	if last >= 0 {
+		return r.interactions[last], true
+	}
+	return Interaction{}, false
+}
+
+// redactedRequest the request as it is written to the cassette, used to match incoming requests
+func (r *Recorder) redactedRequest(req *http.Request, body []byte) RecordedRequest {
+	i := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    r.redactURL(req.URL),
+			Header: req.Header.Clone(),
+		},
+		Response: RecordedResponse{Header: http.Header{}},
+	}
+	i.Request.Body, i.Request.BodyEncoding = encodeBody(decodeContent(i.Request.Header, body))
+	r.redact(&i)
+	return i.Request
+}
+
+// redact redact the headers and the body fields and apply the custom redaction
+func (r *Recorder) redact(i *Interaction) {
+	for _, h := range r.RedactHeaders {
+		for _, header := range []http.Header{i.Request.Header, i.Response.Header} {
+			if _, ok := header[http.CanonicalHeaderKey(h)]; ok {
+				header.Set(h, Redacted)
+			}
+		}
+	}
+	i.Request.Body = r.redactBody(i.Request.Body, i.Request.BodyEncoding, i.Request.Header.Get("Content-Type"))
+	i.Response.Body = r.redactBody(i.Response.Body, i.Response.BodyEncoding, i.Response.Header.Get("Content-Type"))
+	if r.Redact != nil {
+		r.Redact(i)
+	}
+}
+
+// redactBody body with the values of RedactFields replaced, json and form bodies only, the body is kept as is if nothing was replaced
+func (r *Recorder) redactBody(body string, encoding string, contentType string) string {
+	if body == "" || encoding != "" || len(r.RedactFields) == 0 {
+		return body
+	}
+	fields := make(map[string]bool, len(r.RedactFields))
+	for _, f := range r.RedactFields {
+		fields[strings.ToLower(f)] = true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return body
+		}
+		changed := false
+		for key, values := range form {
+			if fields[strings.ToLower(key)] {
+				for n := range values {
+					values[n] = Redacted
+				}
+				changed = true
+			}
+		}
+		if !changed {
+			return body
+		}
+		return form.Encode()
+	}
+	var v interface{}
+	if json.Unmarshal([]byte(body), &v) != nil || !redactJSON(v, fields) {
+		return body
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return string(b)
+}
+
+// redactJSON replace the values of fields in a decoded json value, report whether any was replaced
+func redactJSON(v interface{}, fields map[string]bool) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if fields[strings.ToLower(key)] {
+				v[key] = Redacted
+				changed = true
+			} else if redactJSON(value, fields) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if redactJSON(value, fields) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// redactURL url with the redacted query parameters replaced, incoming requests are redacted the same way before matching
+func (r *Recorder) redactURL(u *url.URL) string {
+	if len(r.RedactQuery) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	for _, key := range r.RedactQuery {
+		if values, ok := query[key]; ok {
+			for n := range values {
+				values[n] = Redacted
+			}
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+// response build the http response of a recorded response
+func (rr RecordedResponse) response(req *http.Request) *http.Response {
+	body, _ := decodeBody(rr.Body, rr.BodyEncoding)
+	header := rr.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.StatusCode, http.StatusText(rr.StatusCode)),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// matchKey method, url with the query normalized and the body normalized
+func matchKey(method string, rawURL string, contentType string, body []byte) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method + " " + rawURL + "\n" + string(body)
+	}
+	query := normalizeQuery(u.Query())
+	u.RawQuery = ""
+	u.Fragment = ""
+	return method + " " + u.String() + "?" + query + "\n" + normalizeBody(contentType, body)
+}
+
+// normalizeQuery query encoded with sorted keys and sorted values
+func normalizeQuery(query url.Values) string {
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	return query.Encode()
+}
+
+// normalizeBody json bodies are compacted with sorted keys, form bodies are normalized like the query
+func normalizeBody(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			return normalizeQuery(form)
+		}
+	}
+	var v interface{}
+	if json.Unmarshal(body, &v) == nil {
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return string(body)
+}
+
+// decodeContent body with its Content-Encoding undone, Content-Encoding and Content-Length are removed from header
+// the body and header are kept as is if a coding is unknown or fails to decode
+func decodeContent(header http.Header, body []byte) []byte {
+	if len(body) == 0 || header.Get("Content-Encoding") == "" {
+		return body
+	}
+	codings := strings.Split(strings.ToLower(header.Get("Content-Encoding")), ",")
+	decoded := body
+	// codings are listed in the order they were applied
+	for n := len(codings) - 1; n >= 0; n-- {
+		var rc io.ReadCloser
+		var err error
+		switch strings.TrimSpace(codings[n]) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			rc, err = gzip.NewReader(bytes.NewReader(decoded))
+		case "deflate":
+			rc, err = zlib.NewReader(bytes.NewReader(decoded))
+			if err != nil {
+				rc, err = flate.NewReader(bytes.NewReader(decoded)), nil
+			}
+		case "br":
+			rc = io.NopCloser(brotli.NewReader(bytes.NewReader(decoded)))
+		case "zstd":
+			var d *zstd.Decoder
+			d, err = zstd.NewReader(bytes.NewReader(decoded), zstd.WithDecoderConcurrency(1))
+			if err == nil {
+				rc = d.IOReadCloser()
+			}
+		default:
+			return body
+		}
+		if err != nil {
+			return body
+		}
+		decoded, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return body
+		}
+	}
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	return decoded
+}
+
+// encodeBody body as a string, base64 encoded unless it is valid utf-8
+func encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// decodeBody inverse of encodeBody
+func decodeBody(body string, encoding string) ([]byte, error) {
+	if strings.EqualFold(encoding, "base64") {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
@@ -0,0 +1,249 @@
+package raytest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorderRecordAndReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.jsonl")
+	mock := New()
+	mock.On(http.MethodGet, "/search").Reply(http.StatusOK, "first").Reply(http.StatusOK, "second")
+	mock.On(http.MethodPost, "/items").ReplyWith(Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Set-Cookie": []string{"session=secret"}},
+		Body:       []byte{0x1f, 0x8b, 0xff},
+	})
+
+	rec, err := NewRecorder(file, ModeRecord)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Transport = mock
+	rec.RedactQuery = []string{"key"}
+	client := &http.Client{Transport: rec}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/search?q=ray&key=secret&a=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if code, body := get(t, client, req); code != http.StatusOK || body != "first" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	if code, body := get(t, client, req); code != http.StatusOK || body != "second" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "http://api.test/items", strings.NewReader(`{"name":"ray","n":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	if code, _ := get(t, client, req); code != http.StatusCreated {
+		t.Errorf("Unexpected status code: %d", code)
+	}
+	err = rec.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// secrets are not written
+	raw, _ := os.ReadFile(file)
+	if strings.Contains(string(raw), "secret") || strings.Count(string(raw), "\n") != 3 {
+		t.Errorf("Unexpected cassette: %s", string(raw))
+	}
+
+	// replay offline, the query order and the json formatting do not matter
+	rec, err = NewRecorder(file, ModeReplay)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Strict = true
+	rec.RedactQuery = []string{"key"}
+	client = &http.Client{Transport: rec}
+	req, _ = http.NewRequest(http.MethodGet, "http://api.test/search?a=1&key=other&q=ray", nil)
+	for _, expected := range []string{"first", "second", "second"} {
+		if code, body := get(t, client, req); code != http.StatusOK || body != expected {
+			t.Errorf("Unexpected response: %d %s", code, body)
+		}
+	}
+	req, _ = http.NewRequest(http.MethodPost, "http://api.test/items", strings.NewReader(`{ "n": 1, "name": "ray" }`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || string(body) != "\x1f\x8b\xff" || resp.Header.Get("Set-Cookie") != Redacted {
+		t.Errorf("Unexpected response: %d %q %v", resp.StatusCode, string(body), resp.Header)
+	}
+	if len(mock.Calls()) != 3 {
+		t.Errorf("Unexpected calls to the real transport: %d", len(mock.Calls()))
+	}
+
+	// strict mode fails unmatched requests
+	_, err = client.Get("http://api.test/search?q=other")
+	if !errors.Is(err, ErrUnmatched) || len(rec.Unmatched()) != 1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRecorderNewInteractions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.jsonl")
+	mock := New()
+	mock.On(http.MethodGet, "/*").Reply(http.StatusOK, "live")
+
+	rec, err := NewRecorder(file, ModeReplay)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Transport = mock
+	rec.Redact = func(i *Interaction) {
+		i.Response.Body = strings.ReplaceAll(i.Response.Body, "live", "recorded")
+	}
+	client := &http.Client{Transport: rec}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/a", nil)
+	if code, body := get(t, client, req); code != http.StatusOK || body != "live" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	// replayed from the recorded interaction
+	if code, body := get(t, client, req); code != http.StatusOK || body != "recorded" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	if len(mock.Calls()) != 1 {
+		t.Errorf("Unexpected calls to the real transport: %d", len(mock.Calls()))
+	}
+	err = rec.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec, err = NewRecorder(file, ModeReplay)
+	if err != nil || len(rec.Interactions()) != 1 {
+		t.Errorf("Unexpected cassette: %v %d", err, len(rec.Interactions()))
+	}
+}
+
+func TestCassette(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.jsonl")
+	mock := New()
+	mock.On(http.MethodGet, "/a").Reply(http.StatusOK, "a")
+
+	t.Run("record", func(t *testing.T) {
+		t.Setenv(RecordEnv, "1")
+		rec := Cassette(t, file)
+		rec.Transport = mock
+		req, _ := http.NewRequest(http.MethodGet, "http://api.test/a", nil)
+		get(t, &http.Client{Transport: rec}, req)
+	})
+	t.Run("replay", func(t *testing.T) {
+		rec := Cassette(t, file)
+		req, _ := http.NewRequest(http.MethodGet, "http://api.test/a", nil)
+		if code, body := get(t, &http.Client{Transport: rec}, req); code != http.StatusOK || body != "a" {
+			t.Errorf("Unexpected response: %d %s", code, body)
+		}
+	})
+	if len(mock.Calls()) != 1 {
+		t.Errorf("Unexpected calls to the real transport: %d", len(mock.Calls()))
+	}
+}
+
+func TestRecorderRedactMatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.jsonl")
+	mock := New()
+	mock.On(http.MethodPost, "/accounts/*/login").ReplyJSON(http.StatusOK, map[string]string{"access_token": "secret-token"})
+	redactAccount := func(i *Interaction) {
+		i.Request.URL = strings.Replace(i.Request.URL, "/accounts/12345/", "/accounts/ACCOUNT/", 1)
+	}
+	login := func(client *http.Client) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, "http://api.test/accounts/12345/login", strings.NewReader(`{"user":"ray","password":"secret-password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		return get(t, client, req)
+	}
+
+	rec, err := NewRecorder(file, ModeRecord)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Transport = mock
+	rec.Redact = redactAccount
+	if code, _ := login(&http.Client{Transport: rec}); code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d", code)
+	}
+	if err = rec.Save(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	raw, _ := os.ReadFile(file)
+	if strings.Contains(string(raw), "secret") || strings.Contains(string(raw), "12345") {
+		t.Errorf("Unexpected cassette: %s", string(raw))
+	}
+
+	// the incoming request is redacted the same way before matching
+	rec, err = NewRecorder(file, ModeReplay)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Strict = true
+	rec.Redact = redactAccount
+	if code, body := login(&http.Client{Transport: rec}); code != http.StatusOK || !strings.Contains(body, Redacted) {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	if unmatched := rec.Unmatched(); len(unmatched) != 0 {
+		t.Errorf("Unexpected unmatched requests: %v", unmatched)
+	}
+}
+
+func TestRecorderCompressedBodies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.jsonl")
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.Bytes()
+	}
+	mock := New()
+	mock.On(http.MethodPost, "/token").ReplyWith(Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}},
+		Body:       gzipped(`{"access_token":"secret-token","expires_in":3600}`),
+	})
+	login := func(client *http.Client, body string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, "http://api.test/token", bytes.NewReader(gzipped(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Accept-Encoding", "gzip")
+		return get(t, client, req)
+	}
+
+	rec, err := NewRecorder(file, ModeRecord)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Transport = mock
+	if code, _ := login(&http.Client{Transport: rec}, `{"user":"ray","password":"secret-password"}`); code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d", code)
+	}
+	if err = rec.Save(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// bodies are stored decoded and redacted
+	raw, _ := os.ReadFile(file)
+	if strings.Contains(string(raw), "secret") || strings.Contains(string(raw), "base64") || strings.Contains(string(raw), "Content-Encoding") {
+		t.Errorf("Unexpected cassette: %s", string(raw))
+	}
+
+	// a compressed request matches on its decoded body
+	rec, err = NewRecorder(file, ModeReplay)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec.Strict = true
+	code, body := login(&http.Client{Transport: rec}, `{ "password": "other", "user": "ray" }`)
+	if code != http.StatusOK || !strings.Contains(body, `"access_token":"REDACTED"`) {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+}
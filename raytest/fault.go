@@ -0,0 +1,231 @@
+package raytest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrConnectionReset error of an injected connection reset, errors.Is(err, syscall.ECONNRESET) holds
+var ErrConnectionReset error = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+// Fault misbehaviour injected into a request
+type Fault struct {
+	// Latency delay before the request is sent
+	Latency time.Duration
+	// Reset fail the request with ErrConnectionReset
+	Reset bool
+	// Timeout hang until the request context is done
+	Timeout bool
+	// StatusCode answer with the status code and Body, the upstream is not called
+	StatusCode int
+	Body       []byte
+	// Truncate cut the response body after TruncateAfter bytes with io.ErrUnexpectedEOF
+	Truncate      bool
+	TruncateAfter int
+	// DripBytes DripInterval deliver the response body DripBytes at a time, waiting DripInterval before each chunk
+	DripBytes    int
+	DripInterval time.Duration
+}
+
+// FaultRule rule of a FaultInjector, an empty Method, Host or Path matches any
+type FaultRule struct {
+	Method string
+	// Host host of the request, matched with path.Match
+	Host string
+	// Path path of the request, matched with path.Match
+	Path string
+	// Probability probability of the fault, 0 means never and 1 always
+	Probability float64
+	Fault       Fault
+}
+
+// FaultInjector transport middleware injecting faults by rule, use with ray.WithMiddleware(injector.Wrap)
+// rules are tried in order and the first rule matching the request and winning the draw applies
+// the draws come from a seeded rng, sequential requests see the same faults on every run
+type FaultInjector struct {
+	rules []FaultRule
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	injected int
+}
+
+// NewFaultInjector new fault injector with a seeded rng
+func NewFaultInjector(seed int64, rules ...FaultRule) *FaultInjector {
+	return &FaultInjector{
+		rules: rules,
+		rng:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Wrap wrap the transport, http.DefaultTransport if nil
+func (f *FaultInjector) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{injector: f, next: next}
+}
+
+// Injected number of requests a fault was injected into
+func (f *FaultInjector) Injected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+// fault fault of the request, nil if no rule applies
+func (f *FaultInjector) fault(req *http.Request) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if !rule.match(req) {
+			continue
+		}
+		if rule.Probability <= 0 || (rule.Probability < 1 && f.rng.Float64() >= rule.Probability) {
+			continue
+		}
+		f.injected++
+		return &rule.Fault
+	}
+	return nil
+}
+
+// match whether the rule matches the request
+func (r *FaultRule) match(req *http.Request) bool {
+	if r.Method != "" && r.Method != req.Method {
+		return false
+	}
+	if r.Host != "" {
+		if ok, _ := path.Match(r.Host, req.URL.Hostname()); !ok {
+			return false
+		}
+	}
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, req.URL.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type faultTransport struct {
+	injector *FaultInjector
+	next     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault := t.injector.fault(req)
+	if fault == nil {
+		return t.next.RoundTrip(req)
+	}
+	ctx := req.Context()
+	if fault.Latency > 0 {
+		err := sleep(ctx, fault.Latency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fault.Timeout {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if fault.Reset {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrConnectionReset
+	}
+	var resp *http.Response
+	if fault.StatusCode != 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		resp = &http.Response{
+			Status:        fmt.Sprintf("%d %s", fault.StatusCode, http.StatusText(fault.StatusCode)),
+			StatusCode:    fault.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Body:          io.NopCloser(bytes.NewReader(fault.Body)),
+			ContentLength: int64(len(fault.Body)),
+			Request:       req,
+		}
+	} else {
+		var err error
+		resp, err = t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fault.Truncate {
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, remain: fault.TruncateAfter}
+		resp.ContentLength = -1
+	}
+	if fault.DripBytes > 0 {
+		resp.Body = &dripBody{ReadCloser: resp.Body, ctx: ctx, chunk: fault.DripBytes, interval: fault.DripInterval}
+	}
+	return resp, nil
+}
+
+// truncatedBody body cut after remain bytes
+type truncatedBody struct {
+	io.ReadCloser
+	remain int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= n
+	return n, err
+}
+
+// dripBody body delivered chunk bytes at a time
+type dripBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	chunk    int
+	interval time.Duration
+}
+
+func (b *dripBody) Read(p []byte) (int, error) {
+	if b.interval > 0 {
+		err := sleep(b.ctx, b.interval)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > b.chunk {
+		p = p[:b.chunk]
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// sleep wait d, return early with the error of the context
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+package raytest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectorRules(t *testing.T) {
+	mock := New()
+	mock.On("", "/*").Reply(http.StatusOK, "0123456789")
+	injector := NewFaultInjector(1,
+		FaultRule{Path: "/reset", Probability: 1, Fault: Fault{Reset: true}},
+		FaultRule{Method: http.MethodGet, Path: "/status", Probability: 1, Fault: Fault{StatusCode: http.StatusServiceUnavailable, Body: []byte("down")}},
+		FaultRule{Host: "*.slow.test", Probability: 1, Fault: Fault{Latency: 30 * time.Millisecond}},
+		FaultRule{Path: "/truncate", Probability: 1, Fault: Fault{Truncate: true, TruncateAfter: 4}},
+		FaultRule{Path: "/timeout", Probability: 1, Fault: Fault{Timeout: true}},
+	)
+	client := &http.Client{Transport: injector.Wrap(mock)}
+
+	_, err := client.Get("http://api.test/reset")
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://api.test/status", nil)
+	if code, body := get(t, client, req); code != http.StatusServiceUnavailable || body != "down" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	// the upstream is not called
+	mock.AssertNotCalled(t, "", "/status")
+	// the rule matches GET only
+	req, _ = http.NewRequest(http.MethodPost, "http://api.test/status", nil)
+	if code, _ := get(t, client, req); code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d", code)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://api.slow.test/a", nil)
+	start := time.Now()
+	if code, _ := get(t, client, req); code != http.StatusOK || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("Unexpected response: %d %v", code, time.Since(start))
+	}
+
+	resp, err := client.Get("http://api.test/truncate")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, io.ErrUnexpectedEOF) || string(body) != "0123" {
+		t.Errorf("Unexpected body: %v %s", err, string(body))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://api.test/timeout", nil)
+	_, err = client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if injector.Injected() != 5 {
+		t.Errorf("Unexpected injected count: %d", injector.Injected())
+	}
+}
+
+func TestFaultInjectorDrip(t *testing.T) {
+	mock := New()
+	mock.On(http.MethodGet, "/stream").Reply(http.StatusOK, "abcdef")
+	injector := NewFaultInjector(1, FaultRule{Probability: 1, Fault: Fault{DripBytes: 2, DripInterval: 10 * time.Millisecond}})
+	client := &http.Client{Transport: injector.Wrap(mock)}
+
+	resp, err := client.Get("http://api.test/stream")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 10)
+	n, _ := resp.Body.Read(buf)
+	if n != 2 {
+		t.Errorf("Unexpected chunk size: %d", n)
+	}
+	start := time.Now()
+	rest, _ := io.ReadAll(resp.Body)
+	if string(buf[:n])+string(rest) != "abcdef" || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Unexpected body: %s %v", string(rest), time.Since(start))
+	}
+}
+
+func TestFaultInjectorSeeded(t *testing.T) {
+	pattern := func(seed int64) []int {
+		mock := New()
+		mock.On("", "/*").Reply(http.StatusOK, "ok")
+		injector := NewFaultInjector(seed, FaultRule{Probability: 0.5, Fault: Fault{StatusCode: http.StatusInternalServerError}})
+		client := &http.Client{Transport: injector.Wrap(mock)}
+		var codes []int
+		for i := 0; i < 20; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://api.test/a", nil)
+			code, _ := get(t, client, req)
+			codes = append(codes, code)
+		}
+		return codes
+	}
+	first, second := pattern(42), pattern(42)
+	failed := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("Unexpected pattern: %v %v", first, second)
+			break
+		}
+		if first[i] == http.StatusInternalServerError {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(first) {
+		t.Errorf("Unexpected pattern: %v", first)
+	}
+}
+
+func TestFaultInjectorDisabled(t *testing.T) {
+	mock := New()
+	mock.On("", "/*").Reply(http.StatusOK, "ok")
+	// a rule with a zero probability never fires
+	injector := NewFaultInjector(1, FaultRule{Fault: Fault{StatusCode: http.StatusInternalServerError}})
+	client := &http.Client{Transport: injector.Wrap(mock)}
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://api.test/a", nil)
+		if code, _ := get(t, client, req); code != http.StatusOK {
+			t.Errorf("Unexpected status code: %d", code)
+		}
+	}
+	if injected := injector.Injected(); injected != 0 {
+		t.Errorf("Unexpected injected faults: %d", injected)
+	}
+}
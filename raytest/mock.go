@@ -0,0 +1,339 @@
+// Package raytest scriptable mock of http services for tests of code using ray
+// a Mock is both an in-process http.RoundTripper, used with ray.WithTransport, and an http.Handler for httptest
+package raytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInjected default error of Route.Fail
+var ErrInjected = errors.New("raytest.injected.failure")
+
+// Matcher match a request, body is the request body already read
+type Matcher func(req *http.Request, body []byte) bool
+
+// Response canned response of a route
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Delay latency before the response is returned, cut short when the request context is done
+	Delay time.Duration
+	// Err transport error returned instead of the response
+	Err error
+}
+
+// Call recorded request
+type Call struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+	// Route matched route, nil if no route matched
+	Route *Route
+}
+
+// Route route of the mock, created by Mock.On and configured by chaining
+type Route struct {
+	mock      *Mock
+	method    string
+	path      string
+	matchers  []Matcher
+	responses []Response
+	delay     time.Duration
+	calls     int
+}
+
+// Mock scriptable mock, routes are matched in the order they were added
+// requests matching no route are recorded and answered with 404
+type Mock struct {
+	mu     sync.Mutex
+	routes []*Route
+	calls  []Call
+}
+
+// New new mock without routes
+func New() *Mock {
+	return &Mock{}
+}
+
+// On add a route, method "" matches any method, path is matched with path.Match, eg. /users/*
+func (m *Mock) On(method string, p string) *Route {
+	r := &Route{mock: m, method: method, path: p}
+	m.mu.Lock()
+	m.routes = append(m.routes, r)
+	m.mu.Unlock()
+	return r
+}
+
+// Client http client doing requests in process against the mock
+func (m *Mock) Client() *http.Client {
+	return &http.Client{Transport: m}
+}
+
+// Calls recorded requests in order, including the unmatched ones
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// Unmatched recorded requests which matched no route
+func (m *Mock) Unmatched() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var calls []Call
+	for _, c := range m.calls {
+		if c.Route == nil {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forget the recorded requests and rewind the response sequences, routes are kept
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+	for _, r := range m.routes {
+		r.calls = 0
+	}
+}
+
+// RoundTrip implements http.RoundTripper, no sockets are involved
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := m.respond(req)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler, an injected failure aborts the connection
+func (m *Mock) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp, err := m.respond(req)
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// respond record the request and pick the response of the matched route
+func (m *Mock) respond(req *http.Request) (Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return Response{}, errors.WithMessage(err, "raytest.request.body.readall")
+		}
+	}
+	m.mu.Lock()
+	route := m.match(req, body)
+	m.calls = append(m.calls, Call{
+		Method: req.Method,
+		URL:    req.URL,
+		Header: req.Header.Clone(),
+		Body:   body,
+		Route:  route,
+	})
+	var resp Response
+	var delay time.Duration
+	if route == nil {
+		resp = Response{
+			StatusCode: http.StatusNotFound,
+			Body:       []byte("raytest: no route for " + req.Method + " " + req.URL.String()),
+		}
+	} else {
+		resp = route.next()
+		delay = route.delay
+	}
+	m.mu.Unlock()
+
+	delay += resp.Delay
+	if delay > 0 {
+		err := sleep(req.Context(), delay)
+		if err != nil {
+			return Response{}, err
+		}
+	}
+	if resp.Err != nil {
+		return Response{}, resp.Err
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	return resp, nil
+}
+
+// match first route matching the request, the caller holds m.mu
+func (m *Mock) match(req *http.Request, body []byte) *Route {
+	for _, r := range m.routes {
+		if r.match(req, body) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Query match a query parameter
+func (r *Route) Query(key string, value string) *Route {
+	return r.Match(func(req *http.Request, body []byte) bool {
+		values, ok := req.URL.Query()[key]
+		if !ok {
+			return false
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Header match a request header
+func (r *Route) Header(key string, value string) *Route {
+	return r.Match(func(req *http.Request, body []byte) bool {
+		for _, v := range req.Header.Values(key) {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// JSON match a json body equal to v, the formatting and the order of the keys do not matter
+func (r *Route) JSON(v interface{}) *Route {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(errors.WithMessage(err, "raytest.route.json.marshal"))
+	}
+	var want interface{}
+	json.Unmarshal(b, &want)
+	return r.Match(func(req *http.Request, body []byte) bool {
+		var got interface{}
+		if json.Unmarshal(body, &got) != nil {
+			return false
+		}
+		return reflect.DeepEqual(got, want)
+	})
+}
+
+// Match match with a custom matcher
+func (r *Route) Match(fn Matcher) *Route {
+	r.mock.mu.Lock()
+	defer r.mock.mu.Unlock()
+	r.matchers = append(r.matchers, fn)
+	return r
+}
+
+// Reply add a response to the sequence of the route
+func (r *Route) Reply(statusCode int, body string) *Route {
+	return r.ReplyWith(Response{StatusCode: statusCode, Body: []byte(body)})
+}
+
+// ReplyJSON add a json response to the sequence of the route
+func (r *Route) ReplyJSON(statusCode int, v interface{}) *Route {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(errors.WithMessage(err, "raytest.route.replyjson.marshal"))
+	}
+	return r.ReplyWith(Response{
+		StatusCode: statusCode,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       b,
+	})
+}
+
+// ReplyWith add a response to the sequence of the route
+// responses are returned in order, the last one is repeated once the sequence is used up
+func (r *Route) ReplyWith(resp Response) *Route {
+	r.mock.mu.Lock()
+	defer r.mock.mu.Unlock()
+	r.responses = append(r.responses, resp)
+	return r
+}
+
+// Fail add a transport failure to the sequence of the route, ErrInjected if err is nil
+func (r *Route) Fail(err error) *Route {
+	if err == nil {
+		err = ErrInjected
+	}
+	return r.ReplyWith(Response{Err: err})
+}
+
+// Delay set the latency of every response of the route
+func (r *Route) Delay(d time.Duration) *Route {
+	r.mock.mu.Lock()
+	defer r.mock.mu.Unlock()
+	r.delay = d
+	return r
+}
+
+// CallCount number of requests matched by the route
+func (r *Route) CallCount() int {
+	r.mock.mu.Lock()
+	defer r.mock.mu.Unlock()
+	return r.calls
+}
+
+// match whether the request is matched by the route, the caller holds the mock lock
+func (r *Route) match(req *http.Request, body []byte) bool {
+	if r.method != "" && r.method != req.Method {
+		return false
+	}
+	if ok, _ := path.Match(r.path, req.URL.Path); !ok {
+		return false
+	}
+	for _, fn := range r.matchers {
+		if !fn(req, body) {
+			return false
+		}
+	}
+	return true
+}
+
+// next next response of the sequence, the caller holds the mock lock
+func (r *Route) next() Response {
+	r.calls++
+	if len(r.responses) == 0 {
+		return Response{StatusCode: http.StatusOK}
+	}
+	i := r.calls - 1
+	if i >= len(r.responses) {
+		i = len(r.responses) - 1
+	}
+	return r.responses[i]
+}
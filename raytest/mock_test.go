@@ -0,0 +1,176 @@
+package raytest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder testing.TB which records the errors instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func get(t *testing.T, client *http.Client, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestMockMatchers(t *testing.T) {
+	mock := New()
+	mock.On(http.MethodGet, "/items").Query("page", "2").Reply(http.StatusOK, "page 2")
+	mock.On(http.MethodGet, "/items").Header("X-Tenant", "a").Reply(http.StatusOK, "tenant a")
+	mock.On(http.MethodPost, "/items").JSON(map[string]interface{}{"name": "ray", "n": 1}).Reply(http.StatusCreated, "created")
+	mock.On("", "/items/*").Reply(http.StatusOK, "item")
+	client := mock.Client()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://mock/items?page=2", nil)
+	if code, body := get(t, client, req); code != http.StatusOK || body != "page 2" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://mock/items", nil)
+	req.Header.Set("X-Tenant", "a")
+	if code, body := get(t, client, req); code != http.StatusOK || body != "tenant a" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "http://mock/items", strings.NewReader(`{"n":1, "name":"ray"}`))
+	if code, body := get(t, client, req); code != http.StatusCreated || body != "created" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, "http://mock/items/1", nil)
+	if code, body := get(t, client, req); code != http.StatusOK || body != "item" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	// no route
+	req, _ = http.NewRequest(http.MethodPost, "http://mock/items", strings.NewReader(`{"name":"other"}`))
+	if code, _ := get(t, client, req); code != http.StatusNotFound {
+		t.Errorf("Unexpected status code: %d", code)
+	}
+
+	if len(mock.Calls()) != 5 || len(mock.Unmatched()) != 1 {
+		t.Errorf("Unexpected calls: %d %d", len(mock.Calls()), len(mock.Unmatched()))
+	}
+	if mock.Calls()[2].Route == nil || string(mock.Calls()[2].Body) != `{"n":1, "name":"ray"}` {
+		t.Errorf("Unexpected call: %+v", mock.Calls()[2])
+	}
+}
+
+func TestMockSequence(t *testing.T) {
+	mock := New()
+	route := mock.On(http.MethodGet, "/job").Reply(http.StatusAccepted, "pending").Fail(nil).Reply(http.StatusOK, "done")
+	client := mock.Client()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://mock/job", nil)
+	if code, body := get(t, client, req); code != http.StatusAccepted || body != "pending" {
+		t.Errorf("Unexpected response: %d %s", code, body)
+	}
+	_, err := client.Get("http://mock/job")
+	if !errors.Is(err, ErrInjected) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// the last response is repeated
+	for i := 0; i < 2; i++ {
+		if code, body := get(t, client, req); code != http.StatusOK || body != "done" {
+			t.Errorf("Unexpected response: %d %s", code, body)
+		}
+	}
+	if route.CallCount() != 4 {
+		t.Errorf("Unexpected call count: %d", route.CallCount())
+	}
+
+	mock.Reset()
+	if code, body := get(t, client, req); code != http.StatusAccepted || body != "pending" || len(mock.Calls()) != 1 {
+		t.Errorf("Unexpected response after reset: %d %s", code, body)
+	}
+}
+
+func TestMockDelay(t *testing.T) {
+	mock := New()
+	mock.On(http.MethodGet, "/slow").Delay(time.Second).Reply(http.StatusOK, "slow")
+	mock.On(http.MethodGet, "/fast").ReplyWith(Response{StatusCode: http.StatusOK, Delay: 20 * time.Millisecond})
+	client := mock.Client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://mock/slow", nil)
+	start := time.Now()
+	_, err := client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Unexpected error: %v %v", err, time.Since(start))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://mock/fast", nil)
+	start = time.Now()
+	if code, _ := get(t, client, req); code != http.StatusOK || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Unexpected response: %d %v", code, time.Since(start))
+	}
+}
+
+func TestMockServer(t *testing.T) {
+	mock := New()
+	mock.On(http.MethodGet, "/ok").ReplyJSON(http.StatusOK, map[string]string{"hello": "ray"})
+	mock.On(http.MethodGet, "/fail").Fail(nil)
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/ok", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/json" || string(body) != `{"hello":"ray"}` {
+		t.Errorf("Unexpected response: %s %s", resp.Header.Get("Content-Type"), string(body))
+	}
+
+	// the connection is aborted
+	_, err = http.Get(server.URL + "/fail")
+	if err == nil {
+		t.Errorf("Unexpected success")
+	}
+}
+
+func TestMockAssertions(t *testing.T) {
+	mock := New()
+	mock.On(http.MethodGet, "/a").Reply(http.StatusOK, "a")
+	mock.On(http.MethodGet, "/b").Reply(http.StatusOK, "b")
+	mock.Client().Get("http://mock/a")
+	mock.Client().Get("http://mock/c")
+
+	mock.AssertCalled(t, http.MethodGet, "/a")
+	mock.AssertNotCalled(t, http.MethodGet, "/b")
+	mock.AssertCallCount(t, "", "/*", 2)
+
+	r := &recorder{TB: t}
+	mock.AssertCalled(r, http.MethodGet, "/b")
+	mock.AssertNotCalled(r, http.MethodGet, "/a")
+	mock.AssertCallCount(r, http.MethodGet, "/a", 2)
+	if len(r.errors) != 3 {
+		t.Errorf("Unexpected errors: %v", r.errors)
+	}
+	r.errors = nil
+	// route /b not called, /c not matched
+	mock.AssertExpectations(r)
+	if len(r.errors) != 2 {
+		t.Errorf("Unexpected errors: %v", r.errors)
+	}
+}
@@ -0,0 +1,73 @@
+package ray
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxRedirects same as net/http
+const defaultMaxRedirects = 10
+
+// redirectSensitiveHeaders headers removed when a redirect leaves the host of the original request
+var redirectSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Cookie2", "WWW-Authenticate"}
+
+// RedirectPolicy decide whether to follow the redirect req, via are the requests made so far, oldest first
+// return http.ErrUseLastResponse to stop and use the redirect response, or an error to fail the request
+type RedirectPolicy func(req *http.Request, via []*http.Request) error
+
+// checkRedirect build the CheckRedirect of the http client, the followed urls are appended to chain if not nil
+// sensitive headers are stripped on cross-host redirects before the policy of options is applied
+func checkRedirect(opts *Options, chain *[]*url.URL) func(req *http.Request, via []*http.Request) error {
+	maxRedirects := opts.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	policy := opts.RedirectPolicy
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirects < 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return errors.Errorf("ray.redirect, stopped after %d redirects", maxRedirects)
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for _, name := range redirectSensitiveHeaders {
+				req.Header.Del(name)
+			}
+		}
+		if policy != nil {
+			err := policy(req, via)
+			if err != nil {
+				return err
+			}
+		}
+		if chain != nil {
+			*chain = append(*chain, req.URL)
+		}
+		return nil
+	}
+}
+
+// WithMaxRedirects set max redirects to follow, n <= 0 means no redirects are followed
+func WithMaxRedirects(n int) OptionHandle {
+	return func(opt *Options) {
+		if n <= 0 {
+			n = -1
+		}
+		opt.MaxRedirects = n
+	}
+}
+
+// WithNoRedirects do not follow redirects, the redirect response is subject to the status policy
+func WithNoRedirects() OptionHandle {
+	return WithMaxRedirects(0)
+}
+
+// WithRedirectPolicy set redirect policy, called after max redirects is checked and sensitive headers are stripped
+func WithRedirectPolicy(policy RedirectPolicy) OptionHandle {
+	return func(opt *Options) {
+		opt.RedirectPolicy = policy
+	}
+}
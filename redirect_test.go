@@ -0,0 +1,92 @@
+package ray
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newRedirectTestServer /a -> /b -> /c, /c echoes the Authorization header, /away redirects to target
+func newRedirectTestServer(target string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, target+"/c", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("auth:" + r.Header.Get("Authorization")))
+		}
+	}))
+}
+
+func TestRedirectChain(t *testing.T) {
+	server := newRedirectTestServer("")
+	defer server.Close()
+
+	// Call the function being tested
+	resp, err := DoResponse(NewOptions(WithURL(server.URL+"/a"), WithBearerToken("tok")))
+
+	// Check for errors
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(resp.Body) != "auth:Bearer tok" {
+		t.Errorf("Unexpected response body: %s", resp.Body)
+	}
+	if len(resp.Redirects) != 2 || resp.Redirects[0].Path != "/b" || resp.Redirects[1].Path != "/c" || resp.URL.Path != "/c" {
+		t.Errorf("Unexpected redirects: %v, final %v", resp.Redirects, resp.URL)
+	}
+}
+
+func TestRedirectCrossHost(t *testing.T) {
+	target := newRedirectTestServer("")
+	defer target.Close()
+	server := newRedirectTestServer(target.URL)
+	defer server.Close()
+
+	// Call the function being tested
+	resp, err := DoResponse(NewOptions(WithURL(server.URL+"/away"), WithBearerToken("tok")))
+
+	// Check for errors
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(resp.Body) != "auth:" {
+		t.Errorf("Authorization forwarded to another host: %s", resp.Body)
+	}
+}
+
+func TestRedirectLimits(t *testing.T) {
+	server := newRedirectTestServer("")
+	defer server.Close()
+
+	// no redirects, the redirect response is returned as a status error
+	_, err := Do(NewOptions(WithURL(server.URL+"/a"), WithNoRedirects()))
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusFound || se.Header.Get("Location") != "/b" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// too many redirects
+	_, err = Do(NewOptions(WithURL(server.URL+"/a"), WithMaxRedirects(1)))
+	if err == nil {
+		t.Errorf("Expected too many redirects error, got nil")
+	}
+
+	// policy
+	denied := errors.New("denied")
+	_, err = Do(NewOptions(WithURL(server.URL+"/a"), WithRedirectPolicy(func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == "/c" {
+			return denied
+		}
+		return nil
+	})))
+	if !errors.Is(err, denied) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
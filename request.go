@@ -3,9 +3,9 @@ package ray
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -13,106 +13,194 @@ import (
 	"github.com/pkg/errors"
 )
 
+// maxErrorBodySize upper bound of the body kept in a StatusError of a stream response
+const maxErrorBodySize = 64 << 10
+
 // DoRetry request with retry
 func DoRetry(opts Options) ([]byte, error) {
+	return defaultClient.DoRetry(opts)
+}
+
+// DoRetry request with retry
+func (c *Client) DoRetry(opts Options) ([]byte, error) {
+	resp, err := c.DoResponse(opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// DoResponse request with retry like DoRetry, return the status, headers and redirects along with the body
+func DoResponse(opts Options) (*Response, error) {
+	return defaultClient.DoResponse(opts)
+}
+
+// DoResponse request with retry like DoRetry, return the status, headers and redirects along with the body
+func (c *Client) DoResponse(opts Options) (*Response, error) {
+	err := c.prepare(&opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.doresponse")
+	}
+	return opts.Coalescer.do(opts, c.retry)
+}
+
+// retry do the request, retry up to Options.RetryTimes within Options.TotalTimeout
+func (c *Client) retry(opts Options) (*Response, error) {
+	if opts.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		opts.Context, cancel = context.WithTimeout(opts.context(), opts.TotalTimeout)
+		defer cancel()
+	}
 	attempt := 0
-	buf, err := Do(opts)
-	for err != nil && attempt < opts.RetryTimes {
+	resp, err := c.hedge(opts)
+	for err != nil && attempt < opts.RetryTimes && opts.context().Err() == nil && retryable(err) {
 		if opts.Body != nil {
 			opts.Body.Seek(0, io.SeekStart) // 重置流
 		}
-		buf, err = Do(opts)
+		resp, err = c.hedge(opts)
 		if err == nil {
 			break
 		}
 		attempt++
 	}
-	return buf, err
+	return resp, err
 }
 
 // Do do request
 func Do(opts Options) ([]byte, error) {
+	return defaultClient.Do(opts)
+}
+
+// Do do request
+func (c *Client) Do(opts Options) ([]byte, error) {
+	err := c.prepare(&opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.do")
+	}
+	resp, err := opts.Coalescer.do(opts, c.hedge)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do do a single request with the bound options
+func (c *Client) do(opts Options) (*Response, error) {
 	if opts.URL == "" {
 		return nil, errors.New("invalid url, url:")
 	}
-	ctx := context.Background()
-	if opts.Timeout > 0 {
+	// the cache answers before the resilience layers and the balancer, keyed by the url of the caller
+	lookup, cached, err := opts.Cache.lookup(&opts, c.do)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.do.cache")
+	}
+	if cached != nil {
+		return cached, nil
+	}
+	callerURL := opts.URL
+	reportEndpoint, releaseEndpoint, err := opts.Balancer.pick(&opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.do")
+	}
+	defer releaseEndpoint()
+	ctx := opts.context()
+	if opts.timeout() > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(opts.Timeout))
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout())
 		defer cancel()
 	}
-	reqUrl := opts.URL
-	var err error
-	if opts.Query != nil {
-		qstr, ok := opts.Query.(string)
-		if !ok {
-			qstr, err = Encode(opts.Query)
-			if err != nil {
-				return nil, errors.WithMessage(err, "ray.request.do.query.encode")
-			}
-		}
-		if len(qstr) != 0 {
-			reqUrl = reqUrl + "?" + qstr
-		}
+	req, err := newRequest(ctx, &opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.do")
 	}
-	req, err := http.NewRequestWithContext(ctx, opts.Method, reqUrl, opts.Body)
+	lookup.condition(req)
+	client, closeIdle, err := newClient(&opts)
 	if err != nil {
-		return nil, errors.WithMessage(err, "ray.request.do.request.new")
+		return nil, errors.WithMessage(err, "ray.request.do")
 	}
-	if opts.Header != nil && len(opts.Header) > 0 {
-		for k, v := range opts.Header {
-			req.Header.Add(k, v)
-		}
+	defer closeIdle()
+	// an open circuit fails fast, it takes no slot of the bulkhead nor token of the rate limiter
+	report, err := opts.CircuitBreaker.allow(req)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.request.do")
 	}
-	if opts.ContentType != "" {
-		req.Header.Add("Content-Type", opts.ContentType)
+	release, err := opts.Bulkhead.acquire(req)
+	if err != nil {
+		// the upstream is not to blame for a rejection of the bulkhead or the rate limiter
+		report(nil, context.Canceled)
+		return nil, errors.WithMessage(err, "ray.request.do")
 	}
-	client := http.Client{}
-	if defaultProxy != "" || opts.Proxy != "" {
-		proxyURL := defaultProxy
-		if opts.Proxy != "" {
-			proxyURL = opts.Proxy
-		}
-		up, err := url.Parse(proxyURL)
-		if err != nil {
-			return nil, errors.WithMessage(err, "ray.request.do.proxy.parse")
-		}
-		client.Transport = &http.Transport{
-			Proxy: http.ProxyURL(up),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
+	defer release()
+	observe, err := opts.RateLimiter.wait(req)
+	if err != nil {
+		report(nil, context.Canceled)
+		return nil, errors.WithMessage(err, "ray.request.do")
 	}
+	var redirects []*url.URL
+	client.CheckRedirect = checkRedirect(&opts, &redirects)
 	resp, err := client.Do(req)
 	if err != nil {
+		report(nil, err)
+		reportEndpoint(nil, err)
 		return nil, errors.WithMessage(err, "ray.request.do.request")
 	}
+	observe(resp)
+	if !opts.DisableDecompression {
+		decodeBody(resp, opts.MaxDecompressedSize)
+	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
+	report(resp, err)
+	reportEndpoint(resp, err)
 	if err != nil {
 		return nil, errors.WithMessage(err, "ray.request.do.resp.body.readall")
 	}
+	if answer, answered, err := lookup.complete(req, resp, body, len(redirects) > 0); answered {
+		if err != nil {
+			return nil, errors.WithMessage(err, "ray.request.do.resp.code")
+		}
+		logRequest(&opts, nil)
+		return answer, nil
+	}
 	// request failed
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("ray.request.do.resp.code,[code]%d,[body]\n%+s", resp.StatusCode, string(body))
+	err = checkStatus(resp, body)
+	if err != nil {
+		// retry once with a refreshed token, a refreshed token which is rejected again is not retried at all
+		if resp.StatusCode == http.StatusUnauthorized && opts.TokenSource != nil {
+			if !invalidateToken(req, &opts) {
+				return nil, errors.WithMessage(tokenRejectedError{err}, "ray.request.do.resp.code")
+			}
+			if opts.Body != nil {
+				opts.Body.Seek(0, io.SeekStart)
+			}
+			release()
+			releaseEndpoint()
+			// the retry picks an endpoint again from the url of the caller
+			opts.URL = callerURL
+			return c.do(opts)
+		}
+		return nil, errors.WithMessage(err, "ray.request.do.resp.code")
 	}
 
-	// user defined logger
-	if opts.Logger != nil {
-		opts.Logger(&opts, err)
-	}
-	// global logger
-	if opts.Logger == nil && defaultLogger != nil {
-		defaultLogger(&opts, err)
-	}
+	logRequest(&opts, nil)
 
-	return body, nil
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		URL:        resp.Request.URL,
+		Redirects:  redirects,
+	}, nil
 }
 
 // DoJSON  do request ,and unmarshal the response to json object
 func DoJSON(opts Options, data interface{}) error {
-	body, err := DoRetry(opts)
+	return defaultClient.DoJSON(opts, data)
+}
+
+// DoJSON  do request ,and unmarshal the response to json object
+func (c *Client) DoJSON(opts Options, data interface{}) error {
+	body, err := c.DoRetry(opts)
 	if err != nil {
 		return errors.WithMessage(err, "ray.request.dojson")
 	}
@@ -124,33 +212,207 @@ func DoJSON(opts Options, data interface{}) error {
 }
 
 // DoStream do request with a stream response
+//
+// Timeouts:
+//   - with neither FirstByteTimeout nor StreamIdleTimeout, Options.Timeout bounds the whole stream
+//   - with either of them, Options.Timeout no longer bounds the whole stream, only TotalTimeout does
+//   - with FirstByteTimeout only, Options.Timeout becomes the idle timeout between two reads
+//
+// The status policy and the retry times are the same as DoRetry.
+// A request is only retried while handFn has not been called yet.
+// Once the stream was handed over, the error is returned as is.
 func DoStream(opts Options, handFn StreamHandle) error {
+	return defaultClient.DoStream(opts, handFn)
+}
+
+// DoStream do request with a stream response, see DoStream
+func (c *Client) DoStream(opts Options, handFn StreamHandle) error {
+	err := c.prepare(&opts)
+	if err != nil {
+		return errors.WithMessage(err, "ray.request.dostream")
+	}
+	if opts.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		opts.Context, cancel = context.WithTimeout(opts.context(), opts.TotalTimeout)
+		defer cancel()
+	}
+	attempt := 0
+	handled, err := c.doStream(opts, handFn)
+	for err != nil && !handled && attempt < opts.RetryTimes && opts.context().Err() == nil && retryable(err) {
+		if opts.Body != nil {
+			opts.Body.Seek(0, io.SeekStart) // 重置流
+		}
+		handled, err = c.doStream(opts, handFn)
+		if err == nil {
+			break
+		}
+		attempt++
+	}
+	return err
+}
+
+// doStream do a single stream request, handled reports whether handFn has been called
+func (c *Client) doStream(opts Options, handFn StreamHandle) (handled bool, err error) {
 	if opts.URL == "" {
-		return errors.New("ray.dostream, invalid url, url:")
+		return false, errors.New("ray.dostream, invalid url, url:")
 	}
-	ctx := context.Background()
-	if opts.Timeout > 0 {
+	callerURL := opts.URL
+	reportEndpoint, releaseEndpoint, err := opts.Balancer.pick(&opts)
+	if err != nil {
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	defer releaseEndpoint()
+	ctx := opts.context()
+	// the watchdog guards a long-lived stream, the timeout of an attempt would cut it
+	// so it becomes the idle timeout when only the first byte is guarded, a stream hanging later is still aborted
+	idleTimeout := opts.StreamIdleTimeout
+	if idleTimeout <= 0 && opts.FirstByteTimeout > 0 {
+		idleTimeout = opts.timeout()
+	}
+	if opts.timeout() > 0 && opts.FirstByteTimeout <= 0 && opts.StreamIdleTimeout <= 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(opts.Timeout))
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout())
 		defer cancel()
 	}
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+	req, err := newRequest(ctx, &opts)
+	if err != nil {
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	client, closeIdle, err := newClient(&opts)
+	if err != nil {
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	defer closeIdle()
+	// an open circuit fails fast, it takes no slot of the bulkhead nor token of the rate limiter
+	report, err := opts.CircuitBreaker.allow(req)
+	if err != nil {
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	release, err := opts.Bulkhead.acquire(req)
+	if err != nil {
+		// the upstream is not to blame for a rejection of the bulkhead or the rate limiter
+		report(nil, context.Canceled)
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	defer release()
+	observe, err := opts.RateLimiter.wait(req)
+	if err != nil {
+		report(nil, context.Canceled)
+		return false, errors.WithMessage(err, "ray.request.dostream")
+	}
+	client.CheckRedirect = checkRedirect(&opts, nil)
+	// waiting for a rate limit token or a bulkhead slot does not count against the first byte
+	watchdog := newStreamWatchdog(cancelCause, opts.FirstByteTimeout, idleTimeout)
+	defer watchdog.stop()
+	resp, err := client.Do(req)
+	// a stream is judged by its response, the handler may fail on its own
+	report(resp, err)
+	reportEndpoint(resp, err)
+	if err != nil {
+		return false, errors.WithMessage(streamCause(ctx, err), "ray.request.dostream.request")
+	}
+	observe(resp)
+	resp.Body = watchdog.wrap(resp.Body)
+	if !opts.DisableDecompression {
+		decodeBody(resp, opts.MaxDecompressedSize)
+	}
+	defer resp.Body.Close()
+	// request failed, the error page is not handed to handFn
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		err = checkStatus(resp, body)
+		// retry once with a refreshed token whatever the retry times, a refreshed token which is rejected again is not retried at all
+		if resp.StatusCode == http.StatusUnauthorized && opts.TokenSource != nil {
+			if !invalidateToken(req, &opts) {
+				return false, errors.WithMessage(tokenRejectedError{err}, "ray.request.dostream.resp.code")
+			}
+			if opts.Body != nil {
+				opts.Body.Seek(0, io.SeekStart)
+			}
+			resp.Body.Close()
+			watchdog.stop()
+			release()
+			releaseEndpoint()
+			// the retry picks an endpoint again from the url of the caller
+			opts.URL = callerURL
+			return c.doStream(opts, handFn)
+		}
+		return false, errors.WithMessage(err, "ray.request.dostream.resp.code")
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	err = handFn(reader)
+	logRequest(&opts, err)
+	if err != nil {
+		return true, errors.WithMessage(streamCause(ctx, err), "ray.request.dostream.handfn")
+	}
+	return true, nil
+}
+
+// retryable whether a failed attempt is worth retrying, the fail fast errors are not
+func retryable(err error) bool {
+	var rejected tokenRejectedError
+	return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrBulkheadFull) &&
+		!errors.As(err, &rejected)
+}
+
+// requestURL url of the request with the encoded query
+func requestURL(opts *Options) (string, error) {
 	reqUrl := opts.URL
-	var err error
 	if opts.Query != nil {
 		qstr, ok := opts.Query.(string)
 		if !ok {
+			var err error
 			qstr, err = Encode(opts.Query)
 			if err != nil {
-				return errors.WithMessage(err, "ray.request.dostream.query.encode")
+				return "", errors.WithMessage(err, "query.encode")
 			}
 		}
 		if len(qstr) != 0 {
 			reqUrl = reqUrl + "?" + qstr
 		}
 	}
+	return reqUrl, nil
+}
+
+// newRequest build the http request from options
+func newRequest(ctx context.Context, opts *Options) (*http.Request, error) {
+	req, err := newBareRequest(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = authorize(req, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range opts.RequestHooks {
+		err = hook(req)
+		if err != nil {
+			return nil, errors.WithMessage(err, "request.hook")
+		}
+	}
+	return req, nil
+}
+
+// newBareRequest build the http request from options, without credentials and hooks
+func newBareRequest(ctx context.Context, opts *Options) (*http.Request, error) {
+	reqUrl, err := requestURL(opts)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequestWithContext(ctx, opts.Method, reqUrl, opts.Body)
 	if err != nil {
-		return errors.WithMessage(err, "ray.request.dostream.request.new")
+		return nil, errors.WithMessage(err, "request.new")
+	}
+	// any seekable body can be replayed, eg. by signers and redirects
+	if req.GetBody == nil && opts.Body != nil {
+		body := opts.Body
+		req.GetBody = func() (io.ReadCloser, error) {
+			_, err := body.Seek(0, io.SeekStart)
+			return io.NopCloser(body), err
+		}
 	}
 	if opts.Header != nil && len(opts.Header) > 0 {
 		for k, v := range opts.Header {
@@ -160,33 +422,81 @@ func DoStream(opts Options, handFn StreamHandle) error {
 	if opts.ContentType != "" {
 		req.Header.Add("Content-Type", opts.ContentType)
 	}
-	client := http.Client{}
-	if defaultProxy != "" || opts.Proxy != "" {
-		proxyURL := defaultProxy
-		if opts.Proxy != "" {
-			proxyURL = opts.Proxy
-		}
-		up, err := url.Parse(proxyURL)
+	// the user may negotiate codings on its own, the response is decoded anyway
+	if !opts.DisableDecompression && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+	}
+	return req, nil
+}
+
+// newClient build the http client from options, closeIdle closes the idle connections of a transport built for the request
+// the shared default transport is used unless a proxy, a tls option or a transport level timeout is set,
+// then the transport shared by the requests of the same options is used
+// the middlewares wrap the transport, the first one is the outermost
+func newClient(opts *Options) (*http.Client, func(), error) {
+	client := &http.Client{Jar: opts.Jar}
+	closeIdle := func() {}
+	var transport http.RoundTripper
+	if opts.Transport != nil {
+		transport = opts.Transport
+	} else if opts.hasProxyConfig() || opts.hasTLSConfig() || opts.DialTimeout > 0 || opts.TLSHandshakeTimeout > 0 || opts.ResponseHeaderTimeout > 0 {
+		t, release, err := transports.get(opts)
 		if err != nil {
-			return errors.WithMessage(err, "ray.request.dostream.proxy.parse")
+			return nil, nil, err
 		}
-		client.Transport = &http.Transport{
-			Proxy: http.ProxyURL(up),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+		transport, closeIdle = t, release
+	}
+	if len(opts.Middlewares) > 0 {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		for i := len(opts.Middlewares) - 1; i >= 0; i-- {
+			transport = opts.Middlewares[i](transport)
 		}
 	}
-	resp, err := client.Do(req)
+	client.Transport = transport
+	return client, closeIdle, nil
+}
+
+// newTransport transport built from the proxy, tls and timeout options
+func newTransport(opts *Options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxy, err := proxyFunc(opts)
 	if err != nil {
-		return errors.WithMessage(err, "ray.request.dostream.request")
+		return nil, err
 	}
-	defer resp.Body.Close()
+	transport.Proxy = proxy
+	if opts.hasTLSConfig() {
+		cfg, err := tlsConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = cfg
+	}
+	if opts.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	if opts.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	}
+	return transport, nil
+}
 
-	reader := bufio.NewReader(resp.Body)
-	err = handFn(reader)
-	if err != nil {
-		return errors.WithMessage(err, "ray.request.dostream.handfn")
+// logRequest call the user defined logger, or the global logger if not set
+func logRequest(opts *Options, err error) {
+	// user defined logger
+	if opts.Logger != nil {
+		opts.Logger(opts, err)
+		return
+	}
+	// client logger
+	if logger := opts.defaults().Logger; logger != nil {
+		logger(opts, err)
 	}
-	return nil
 }
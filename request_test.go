@@ -2,11 +2,18 @@ package ray
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/rumis/ray/raytest"
 )
 
 func TestDo(t *testing.T) {
@@ -123,15 +130,17 @@ func TestDoJSON(t *testing.T) {
 }
 
 func TestProxy(t *testing.T) {
+	proxy := newTestProxy()
+	defer proxy.Close()
 
-	SetDefaultProxy("http://127.0.0.1:10808")
+	SetDefaultProxy(proxy.URL)
+	defer SetDefaultProxy("")
 	// Set up the test options
 	opts := Options{
-		URL:     "https://www.google.com",
+		URL:     "http://www.google.com",
 		Method:  http.MethodGet,
 		Timeout: 5,
 		Body:    nil,
-		// Proxy:   "http://127.0.0.1:10808",
 	}
 	// Call the function being tested
 	response, err := Do(opts)
@@ -140,8 +149,98 @@ func TestProxy(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 	// Check the response body
-	fmt.Println(string(response))
+	if !strings.HasPrefix(string(response), "proxied http://www.google.com") {
+		t.Errorf("Unexpected response body: %s", string(response))
+	}
+}
+
+func TestWithTransport(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodPost, "/users").JSON(map[string]string{"name": "ray"}).ReplyJSON(http.StatusOK, map[string]int{"id": 1})
+	mock.On(http.MethodGet, "/users/*").Fail(nil).Reply(http.StatusOK, "ray")
+
+	var created struct {
+		ID int `json:"id"`
+	}
+	err := DoJSON(NewOptions(
+		WithURL("http://mock/users"),
+		WithMethod(http.MethodPost),
+		WithBodyS(`{"name": "ray"}`),
+		WithTransport(mock),
+	), &created)
+	if err != nil || created.ID != 1 {
+		t.Errorf("Unexpected response: %v %+v", err, created)
+	}
+
+	// the injected failure is retried
+	body, err := DoRetry(NewOptions(WithURL("http://mock/users/1"), WithTransport(mock), WithRetryTimes(1)))
+	if err != nil || string(body) != "ray" {
+		t.Errorf("Unexpected response: %v %s", err, string(body))
+	}
+	mock.AssertCallCount(t, http.MethodGet, "/users/*", 2)
+	mock.AssertExpectations(t)
 }
+
+func TestWithMiddleware(t *testing.T) {
+	mock := raytest.New()
+	mock.On(http.MethodGet, "/flaky").Reply(http.StatusOK, "ok")
+	mock.On(http.MethodGet, "/stream").Reply(http.StatusOK, "line1\nline2\n")
+	injector := raytest.NewFaultInjector(7,
+		raytest.FaultRule{Path: "/flaky", Probability: 0.5, Fault: raytest.Fault{Reset: true}},
+		raytest.FaultRule{Path: "/stream", Probability: 1, Fault: raytest.Fault{DripBytes: 1, DripInterval: 100 * time.Millisecond}},
+	)
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	// the injected resets are retried
+	for i := 0; i < 5; i++ {
+		body, err := DoRetry(NewOptions(
+			WithURL("http://mock/flaky"),
+			WithTransport(mock),
+			WithRetryTimes(10),
+			WithMiddleware(trace("outer"), injector.Wrap, trace("inner")),
+		))
+		if err != nil || string(body) != "ok" {
+			t.Errorf("Unexpected response: %v %s", err, string(body))
+		}
+	}
+	if injector.Injected() == 0 || len(mock.Calls()) != 5 {
+		t.Errorf("Unexpected calls: %d injected, %d upstream", injector.Injected(), len(mock.Calls()))
+	}
+	if len(order) != 2*5+injector.Injected() || order[0] != "outer" {
+		t.Errorf("Unexpected middleware order: %v", order)
+	}
+
+	// the slow drip trips the idle timeout
+	err := DoStream(NewOptions(
+		WithURL("http://mock/stream"),
+		WithTransport(mock),
+		WithMiddleware(injector.Wrap),
+		WithFirstByteTimeout(time.Second),
+		WithStreamIdleTimeout(30*time.Millisecond),
+	), func(r *bufio.Reader) error {
+		_, err := io.ReadAll(r)
+		return err
+	})
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// roundTripperFunc http.RoundTripper of a function
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestDoStream(t *testing.T) {
 	// Create a test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -188,3 +287,150 @@ func TestDoStream(t *testing.T) {
 	fmt.Println("Done")
 
 }
+
+func TestDoStreamStatus(t *testing.T) {
+	calls := 0
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// Set the response status code
+		w.WriteHeader(http.StatusInternalServerError)
+		// Set the response body
+		w.Write([]byte("<html>internal error</html>"))
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	opts := Options{
+		URL:        server.URL,
+		Method:     http.MethodGet,
+		Timeout:    5,
+		RetryTimes: 2,
+	}
+
+	handled := false
+	err := DoStream(opts, func(r *bufio.Reader) error {
+		handled = true
+		return nil
+	})
+
+	// Check for errors
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if handled {
+		t.Errorf("Unexpected call of the stream handler")
+	}
+	if calls != 3 {
+		t.Errorf("Unexpected request times. Expected: %d, Got: %d", 3, calls)
+	}
+}
+
+func TestDoStreamNoRetryAfterHandled(t *testing.T) {
+	calls := 0
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// Set the response status code
+		w.WriteHeader(http.StatusOK)
+		// Set the response body
+		w.Write([]byte("Hello, World!\n"))
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	logged := 0
+	opts := Options{
+		URL:        server.URL,
+		Method:     http.MethodGet,
+		Timeout:    5,
+		RetryTimes: 2,
+		Logger: func(opt *Options, err error) error {
+			logged++
+			return nil
+		},
+	}
+
+	err := DoStream(opts, func(r *bufio.Reader) error {
+		return io.ErrUnexpectedEOF
+	})
+
+	// Check for errors
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Unexpected request times. Expected: %d, Got: %d", 1, calls)
+	}
+	if logged != 1 {
+		t.Errorf("Unexpected logger calls. Expected: %d, Got: %d", 1, logged)
+	}
+}
+
+func TestDoTimeoutDuration(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	opts := NewOptions(WithURL(server.URL), WithTimeoutDuration(50*time.Millisecond))
+
+	// Call the function being tested
+	_, err := Do(opts)
+
+	// Check for errors
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDoRetryTotalTimeout(t *testing.T) {
+	var calls int32
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	opts := NewOptions(WithURL(server.URL), WithRetryTimes(10), WithTimeoutDuration(time.Second), WithTotalTimeout(150*time.Millisecond))
+
+	// Call the function being tested
+	start := time.Now()
+	_, err := DoRetry(opts)
+
+	// Check for errors
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Unexpected elapsed time: %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&calls); n > 2 {
+		t.Errorf("Unexpected request times: %d", n)
+	}
+}
+
+func TestWithTimeoutCompatibility(t *testing.T) {
+	opts := NewOptions(WithTimeout(5))
+	if opts.timeout() != 5*time.Second || opts.Timeout != 5 {
+		t.Errorf("Unexpected timeout: %v", opts.timeout())
+	}
+	opts = NewOptions(WithTimeoutDuration(250 * time.Millisecond))
+	if opts.timeout() != 250*time.Millisecond {
+		t.Errorf("Unexpected timeout: %v", opts.timeout())
+	}
+	opts = NewOptions(WithTimeout(0))
+	if opts.timeout() != 0 {
+		t.Errorf("Unexpected timeout: %v", opts.timeout())
+	}
+}
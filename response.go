@@ -0,0 +1,17 @@
+package ray
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Response buffered response of DoResponse
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// URL url of the final request after redirects
+	URL *url.URL
+	// Redirects urls of the redirects followed, in order
+	Redirects []*url.URL
+}
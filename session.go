@@ -0,0 +1,208 @@
+package ray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Session client with a cookie jar, for login-then-call flows against cookie based backends
+// the jar is public suffix aware, cookies can be pre-seeded, exported and persisted to a file between runs
+type Session struct {
+	*Client
+	jar  *sessionJar
+	file string
+}
+
+// SessionCookie cookie with the url it was set for
+type SessionCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// NewSession new session, the cookie jar is added to the default options of cfg
+func NewSession(cfg Config) (*Session, error) {
+	jar, err := newSessionJar()
+	if err != nil {
+		return nil, errors.WithMessage(err, "ray.session.jar")
+	}
+	cfg.Options = append(append([]OptionHandle(nil), cfg.Options...), WithCookieJar(jar))
+	return &Session{Client: NewClient(cfg), jar: jar}, nil
+}
+
+// LoadSession new session persisted in file, the cookies of the file are loaded if it exists, call Save to write it
+func LoadSession(cfg Config, file string) (*Session, error) {
+	s, err := NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	buf, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ray.session.load,[file]%s", file)
+	}
+	var cookies []SessionCookie
+	err = json.Unmarshal(buf, &cookies)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ray.session.load.unmarshal,[file]%s", file)
+	}
+	err = s.Import(cookies)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ray.session.load.import,[file]%s", file)
+	}
+	return s, nil
+}
+
+// Jar cookie jar of the session
+func (s *Session) Jar() http.CookieJar {
+	return s.jar
+}
+
+// SetCookies pre-seed cookies for u
+func (s *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar.SetCookies(u, cookies)
+}
+
+// Cookies cookies sent to u
+func (s *Session) Cookies(u *url.URL) []*http.Cookie {
+	return s.jar.Cookies(u)
+}
+
+// Export all unexpired cookies of the session
+func (s *Session) Export() []SessionCookie {
+	return s.jar.export()
+}
+
+// Import set exported cookies, expired ones are skipped
+func (s *Session) Import(cookies []SessionCookie) error {
+	for _, c := range cookies {
+		if c.Cookie == nil {
+			continue
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return errors.WithMessagef(err, "ray.session.import.url,[url]%s", c.URL)
+		}
+		s.jar.SetCookies(u, []*http.Cookie{c.Cookie})
+	}
+	return nil
+}
+
+// Save write the cookies to the file of LoadSession
+func (s *Session) Save() error {
+	if s.file == "" {
+		return errors.New("ray.session.save, no file, use LoadSession")
+	}
+	return s.SaveTo(s.file)
+}
+
+// SaveTo write the cookies to file, readable by the owner only
+func (s *Session) SaveTo(file string) error {
+	buf, err := json.Marshal(s.Export())
+	if err != nil {
+		return errors.WithMessage(err, "ray.session.save.marshal")
+	}
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return errors.WithMessagef(err, "ray.session.save.write,[file]%s", file)
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return errors.WithMessagef(err, "ray.session.save.rename,[file]%s", file)
+	}
+	return nil
+}
+
+// sessionJar cookiejar.Jar which remembers the cookies it was given, cookiejar.Jar can not list its cookies
+type sessionJar struct {
+	*cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]SessionCookie
+}
+
+func newSessionJar() (*sessionJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	return &sessionJar{Jar: jar, cookies: map[string]SessionCookie{}}, nil
+}
+
+// SetCookies implements http.CookieJar
+func (j *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := domain + ";" + c.Path + ";" + c.Name
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) || !j.accepted(u, c) {
+			delete(j.cookies, key)
+			continue
+		}
+		stored := *c
+		if c.MaxAge > 0 {
+			// persist an absolute expiry, MaxAge is relative to the time it was received
+			stored.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			stored.MaxAge = 0
+		}
+		stored.Raw = ""
+		j.cookies[key] = SessionCookie{URL: (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(), Cookie: &stored}
+	}
+}
+
+// accepted whether the jar kept c, eg. cookies for a public suffix are rejected
+func (j *sessionJar) accepted(u *url.URL, c *http.Cookie) bool {
+	check := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: c.Path}
+	if c.Secure {
+		check.Scheme = "https"
+	}
+	if check.Path == "" {
+		check.Path = u.Path
+	}
+	for _, kept := range j.Jar.Cookies(check) {
+		if kept.Name == c.Name && kept.Value == c.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// export unexpired cookies
+func (j *sessionJar) export() []SessionCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	cookies := make([]SessionCookie, 0, len(j.cookies))
+	for key, c := range j.cookies {
+		if !c.Cookie.Expires.IsZero() && c.Cookie.Expires.Before(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		cookie := *c.Cookie
+		cookies = append(cookies, SessionCookie{URL: c.URL, Cookie: &cookie})
+	}
+	return cookies
+}
+
+// WithCookieJar set cookie jar of the request
+func WithCookieJar(jar http.CookieJar) OptionHandle {
+	return func(opt *Options) {
+		opt.Jar = jar
+	}
+}
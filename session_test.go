@@ -0,0 +1,104 @@
+package ray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newLoginTestServer /login sets the session cookie, /me requires it
+func newLoginTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s-" + r.PostFormValue("user"), Path: "/", MaxAge: 3600})
+			w.WriteHeader(http.StatusOK)
+		case "/me":
+			c, err := r.Cookie("sid")
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(c.Value))
+		}
+	}))
+}
+
+func TestSessionLogin(t *testing.T) {
+	server := newLoginTestServer()
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "cookies.json")
+
+	s, err := LoadSession(Config{Timeout: time.Second}, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// not logged in
+	_, err = s.Get(context.Background(), server.URL+"/me")
+	if err == nil {
+		t.Errorf("Expected unauthorized error, got nil")
+	}
+	// login then call
+	_, err = s.PostForm(context.Background(), server.URL+"/login", map[string]string{"user": "ray"})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	response, err := s.Get(context.Background(), server.URL+"/me")
+	if err != nil || string(response) != "s-ray" {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+	err = s.Save()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// next run
+	s2, err := LoadSession(Config{Timeout: time.Second}, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err = s2.Get(context.Background(), server.URL+"/me")
+	if err != nil || string(response) != "s-ray" {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+	if cookies := s2.Export(); len(cookies) != 1 || cookies[0].Cookie.Expires.IsZero() {
+		t.Errorf("Unexpected exported cookies: %+v", cookies)
+	}
+
+	// a plain client keeps no cookies
+	_, err = NewClient(Config{Timeout: time.Second}).Get(context.Background(), server.URL+"/me")
+	if err == nil {
+		t.Errorf("Expected unauthorized error, got nil")
+	}
+}
+
+func TestSessionSeedAndPublicSuffix(t *testing.T) {
+	server := newLoginTestServer()
+	defer server.Close()
+
+	s, err := NewSession(Config{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	u, _ := url.Parse(server.URL)
+	s.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "seeded", Path: "/"}})
+	response, err := s.Get(context.Background(), server.URL+"/me")
+	if err != nil || string(response) != "seeded" {
+		t.Errorf("Unexpected response: %s, %v", response, err)
+	}
+
+	// cookies for a public suffix are rejected
+	pu, _ := url.Parse("https://www.example.co.uk/")
+	s.SetCookies(pu, []*http.Cookie{{Name: "tracker", Value: "1", Domain: "co.uk"}})
+	if cookies := s.Cookies(pu); len(cookies) != 0 {
+		t.Errorf("Unexpected cookies of a public suffix: %v", cookies)
+	}
+	if cookies := s.Export(); len(cookies) != 1 || cookies[0].Cookie.Name != "sid" {
+		t.Errorf("Unexpected exported cookies: %+v", cookies)
+	}
+}
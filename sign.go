@@ -0,0 +1,234 @@
+package ray
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Signer sign the request, called after the query is encoded and the headers are set
+// body is the raw request body, nil if there is none
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc adapter to use a function as Signer
+type SignerFunc func(req *http.Request, body []byte) error
+
+// Sign implements Signer
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+	return f(req, body)
+}
+
+// WithSigner sign the request with s, it runs as a request hook in the order the options are applied
+func WithSigner(s Signer) OptionHandle {
+	return WithRequestHook(func(req *http.Request) error {
+		body, err := requestBody(req)
+		if err != nil {
+			return errors.WithMessage(err, "sign.body")
+		}
+		return s.Sign(req, body)
+	})
+}
+
+// requestBody read the whole request body and rewind it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body, err = req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// CanonicalRequest parts of a request to be signed by HMACSigner
+type CanonicalRequest struct {
+	Method string
+	// Path escaped path, "/" if empty
+	Path string
+	// Query query sorted by key and value, see CanonicalQuery
+	Query string
+	// BodyHash hex encoded sha256 of the body
+	BodyHash  string
+	Timestamp string
+	Nonce     string
+	// Headers values of HMACSigner.SignedHeaders keyed by lower case name
+	Headers map[string]string
+
+	Request *http.Request
+	Body    []byte
+}
+
+// CanonicalQuery encode the query sorted by key and then by value
+func CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(k))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(v))
+		}
+	}
+	return b.String()
+}
+
+// DefaultCanonicalize join method, path, query, body hash, timestamp, nonce and the signed headers with "\n"
+func DefaultCanonicalize(cr *CanonicalRequest) string {
+	parts := []string{cr.Method, cr.Path, cr.Query, cr.BodyHash, cr.Timestamp, cr.Nonce}
+	names := make([]string, 0, len(cr.Headers))
+	for name := range cr.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+":"+cr.Headers[name])
+	}
+	return strings.Join(parts, "\n")
+}
+
+// HMACSigner sign requests with an HMAC secret
+// the canonical string, the digest, the encoding and the headers are configurable to describe vendor formats,
+// the zero values give hex(hmac-sha256(secret, DefaultCanonicalize)) in X-Signature with X-Timestamp, X-Nonce and X-Key-Id
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+	// Hash digest of the hmac, sha256.New by default
+	Hash func() hash.Hash
+	// Canonicalize build the string to sign, DefaultCanonicalize by default
+	Canonicalize func(cr *CanonicalRequest) string
+	// Encode encode the signature, hex by default
+	Encode func(sig []byte) string
+	// SignedHeaders request headers included in the canonical request
+	SignedHeaders []string
+	// Now clock, time.Now by default
+	Now func() time.Time
+	// Timestamp format the timestamp, unix seconds by default
+	Timestamp func(t time.Time) string
+	// Nonce generate the nonce, 16 random bytes hex encoded by default
+	Nonce func() string
+	// SignatureHeader TimestampHeader NonceHeader KeyIDHeader names of the headers set, a header is skipped if "-"
+	SignatureHeader string
+	TimestampHeader string
+	NonceHeader     string
+	KeyIDHeader     string
+	// Apply set the signature on the request instead of the headers above, eg. a vendor Authorization format
+	Apply func(req *http.Request, cr *CanonicalRequest, signature string) error
+}
+
+// Sign implements Signer
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+	if len(s.Secret) == 0 {
+		return errors.New("ray.sign.hmac, empty secret")
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	if s.Timestamp != nil {
+		timestamp = s.Timestamp(now())
+	}
+	nonce := ""
+	if s.Nonce != nil {
+		nonce = s.Nonce()
+	} else {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return errors.WithMessage(err, "ray.sign.hmac.nonce")
+		}
+		nonce = hex.EncodeToString(b)
+	}
+	bodyHash := sha256.Sum256(body)
+	cr := &CanonicalRequest{
+		Method:    req.Method,
+		Path:      req.URL.EscapedPath(),
+		Query:     CanonicalQuery(req.URL.Query()),
+		BodyHash:  hex.EncodeToString(bodyHash[:]),
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Headers:   make(map[string]string, len(s.SignedHeaders)),
+		Request:   req,
+		Body:      body,
+	}
+	if cr.Path == "" {
+		cr.Path = "/"
+	}
+	for _, name := range s.SignedHeaders {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "host") {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		}
+		cr.Headers[strings.ToLower(name)] = strings.TrimSpace(value)
+	}
+	canonicalize := DefaultCanonicalize
+	if s.Canonicalize != nil {
+		canonicalize = s.Canonicalize
+	}
+	hashFn := sha256.New
+	if s.Hash != nil {
+		hashFn = s.Hash
+	}
+	mac := hmac.New(hashFn, s.Secret)
+	mac.Write([]byte(canonicalize(cr)))
+	encode := hex.EncodeToString
+	if s.Encode != nil {
+		encode = s.Encode
+	}
+	signature := encode(mac.Sum(nil))
+	if s.Apply != nil {
+		return s.Apply(req, cr, signature)
+	}
+	setSignHeader(req, s.SignatureHeader, "X-Signature", signature)
+	setSignHeader(req, s.TimestampHeader, "X-Timestamp", cr.Timestamp)
+	setSignHeader(req, s.NonceHeader, "X-Nonce", cr.Nonce)
+	if s.KeyID != "" {
+		setSignHeader(req, s.KeyIDHeader, "X-Key-Id", s.KeyID)
+	}
+	return nil
+}
+
+// setSignHeader set the header name, or the fallback name if empty, "-" skips the header
+func setSignHeader(req *http.Request, name string, fallback string, value string) {
+	if name == "-" {
+		return
+	}
+	if name == "" {
+		name = fallback
+	}
+	req.Header.Set(name, value)
+}
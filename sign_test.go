@@ -0,0 +1,112 @@
+package ray
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seekOnly hide the concrete type of the reader, so http.NewRequest does not set GetBody
+type seekOnly struct {
+	io.ReadSeeker
+}
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("secret")
+	// Create a test server which verifies the signature
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+		canonical := strings.Join([]string{
+			r.Method, r.URL.EscapedPath(), "a=1&b=2&b=3", hex.EncodeToString(bodyHash[:]),
+			r.Header.Get("X-Timestamp"), r.Header.Get("X-Nonce"), "content-type:application/json",
+		}, "\n")
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(canonical))
+		if r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) || r.Header.Get("X-Key-Id") != "key-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	signer := &HMACSigner{
+		KeyID:         "key-1",
+		Secret:        secret,
+		SignedHeaders: []string{"Content-Type"},
+		Now:           func() time.Time { return time.Unix(1700000000, 0) },
+	}
+	body := `{"amount":100}`
+	opts := NewOptions(
+		WithURL(server.URL+"/pay"),
+		WithMethod("POST"),
+		WithQuery("b=3&a=1&b=2"),
+		WithContentType("application/json"),
+		WithSigner(signer),
+	)
+	opts.Body = seekOnly{strings.NewReader(body)}
+
+	// Call the function being tested
+	response, err := DoRetry(opts)
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != body {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", body, string(response))
+	}
+}
+
+func TestHMACSignerVendorFormat(t *testing.T) {
+	secret := []byte("secret")
+	var authorization string
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	signer := &HMACSigner{
+		KeyID:  "key-1",
+		Secret: secret,
+		Hash:   sha1.New,
+		Encode: base64.StdEncoding.EncodeToString,
+		Now:    func() time.Time { return time.Unix(1700000000, 0) },
+		Nonce:  func() string { return "n" },
+		Canonicalize: func(cr *CanonicalRequest) string {
+			return cr.Method + " " + cr.Path + "?" + cr.Query + " " + cr.Timestamp
+		},
+		Apply: func(req *http.Request, cr *CanonicalRequest, signature string) error {
+			req.Header.Set("Authorization", "HMAC "+url.QueryEscape(cr.Request.URL.Host)+":"+signature)
+			return nil
+		},
+	}
+
+	// Call the function being tested
+	_, err := Do(NewOptions(WithURL(server.URL+"/orders"), WithQuery(map[string]string{"z": "1", "a": "2"}), WithSigner(signer)))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	mac := hmac.New(sha1.New, secret)
+	mac.Write([]byte("GET /orders?a=2&z=1 1700000000"))
+	u, _ := url.Parse(server.URL)
+	expected := "HMAC " + url.QueryEscape(u.Host) + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if authorization != expected {
+		t.Errorf("Unexpected authorization. Expected: %s, Got: %s", expected, authorization)
+	}
+}
@@ -0,0 +1,226 @@
+package ray
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sigV4UnsignedPayload payload hash of the unsigned payload mode
+const sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sigV4IgnoredHeaders headers never signed, they may be changed on the way to the server
+var sigV4IgnoredHeaders = map[string]bool{
+	"authorization":     true,
+	"user-agent":        true,
+	"x-amzn-trace-id":   true,
+	"expect":            true,
+	"accept-encoding":   true,
+	"transfer-encoding": true,
+}
+
+// AWSCredentials credentials of AWS Signature Version 4
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken token of temporary credentials, sent as X-Amz-Security-Token
+	SessionToken string
+}
+
+// AWSCredentialsProvider source of credentials, called for every request, it must cache if retrieving is expensive
+type AWSCredentialsProvider interface {
+	Retrieve(ctx context.Context) (AWSCredentials, error)
+}
+
+// AWSCredentialsFunc adapter to use a function as AWSCredentialsProvider
+type AWSCredentialsFunc func(ctx context.Context) (AWSCredentials, error)
+
+// Retrieve implements AWSCredentialsProvider
+func (f AWSCredentialsFunc) Retrieve(ctx context.Context) (AWSCredentials, error) {
+	return f(ctx)
+}
+
+// StaticAWSCredentials provider of fixed credentials
+func StaticAWSCredentials(accessKeyID string, secretAccessKey string, sessionToken string) AWSCredentialsProvider {
+	creds := AWSCredentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey, SessionToken: sessionToken}
+	return AWSCredentialsFunc(func(ctx context.Context) (AWSCredentials, error) {
+		return creds, nil
+	})
+}
+
+// SigV4Signer sign requests with AWS Signature Version 4
+type SigV4Signer struct {
+	// Service service name of the credential scope, eg. s3
+	Service string
+	// Region region of the credential scope, eg. us-east-1
+	Region      string
+	Credentials AWSCredentialsProvider
+	// UnsignedPayload sign with UNSIGNED-PAYLOAD instead of the body hash, the body is never read, use it for streams
+	UnsignedPayload bool
+	// Now clock, time.Now by default
+	Now func() time.Time
+}
+
+// WithSigV4 sign the request with AWS Signature Version 4
+func WithSigV4(s *SigV4Signer) OptionHandle {
+	if s.UnsignedPayload {
+		return WithRequestHook(func(req *http.Request) error {
+			return s.Sign(req, nil)
+		})
+	}
+	return WithSigner(s)
+}
+
+// Sign implements Signer
+func (s *SigV4Signer) Sign(req *http.Request, body []byte) error {
+	if s.Credentials == nil {
+		return errors.New("ray.sign.sigv4, nil credentials")
+	}
+	creds, err := s.Credentials.Retrieve(req.Context())
+	if err != nil {
+		return errors.WithMessage(err, "ray.sign.sigv4.credentials")
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	isS3 := s.Service == "s3"
+	payloadHash := sigV4UnsignedPayload
+	if !s.UnsignedPayload {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if isS3 || s.UnsignedPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// canonical headers
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if sigV4IgnoredHeaders[name] {
+			continue
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req.URL, isS3),
+		sigV4CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return nil
+}
+
+// sigV4CanonicalURI normalized and twice encoded path, s3 paths are encoded once and never normalized
+func sigV4CanonicalURI(u *url.URL, isS3 bool) string {
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	if isS3 {
+		return sigV4Escape(p, false)
+	}
+	cleaned := path.Clean(p)
+	if cleaned != "/" && strings.HasSuffix(p, "/") {
+		cleaned += "/"
+	}
+	return sigV4Escape(sigV4Escape(cleaned, false), false)
+}
+
+// sigV4CanonicalQuery query sorted by encoded key and then by encoded value, encoded with sigV4Escape
+// the pairs are not sorted as whole strings, a key which prefixes another would sort by "=" against the next character
+func sigV4CanonicalQuery(query url.Values) string {
+	pairs := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{sigV4Escape(k, true), sigV4Escape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	encoded := make([]string, len(pairs))
+	for n, pair := range pairs {
+		encoded[n] = pair[0] + "=" + pair[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// sigV4Escape encode everything but the unreserved characters, "/" is kept unless encodeSlash
+func sigV4Escape(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// hmacSHA256 hmac-sha256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,79 @@
+package ray
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// streamWatchdog cancel the stream request when the first byte or the next bytes do not arrive in time
+type streamWatchdog struct {
+	idle      time.Duration
+	timer     *time.Timer
+	firstByte atomic.Bool
+}
+
+// newStreamWatchdog start a watchdog, firstByte is the time-to-first-byte timeout and idle the timeout between two reads
+// if firstByte is zero the idle timeout is used for the first byte as well, a nil watchdog is returned if both are zero
+func newStreamWatchdog(cancel context.CancelCauseFunc, firstByte time.Duration, idle time.Duration) *streamWatchdog {
+	if firstByte <= 0 {
+		firstByte = idle
+	}
+	if firstByte <= 0 {
+		return nil
+	}
+	w := &streamWatchdog{idle: idle}
+	w.timer = time.AfterFunc(firstByte, func() {
+		if w.firstByte.Load() {
+			cancel(ErrStreamIdleTimeout)
+			return
+		}
+		cancel(ErrFirstByteTimeout)
+	})
+	return w
+}
+
+// wrap reset the watchdog on every read of body
+func (w *streamWatchdog) wrap(body io.ReadCloser) io.ReadCloser {
+	if w == nil {
+		return body
+	}
+	return &watchdogReader{ReadCloser: body, w: w}
+}
+
+// stop stop the watchdog
+func (w *streamWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+}
+
+type watchdogReader struct {
+	io.ReadCloser
+	w *streamWatchdog
+}
+
+// Read implements io.Reader
+func (r *watchdogReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.w.firstByte.Store(true)
+		if r.w.idle > 0 {
+			r.w.timer.Reset(r.w.idle)
+		} else {
+			r.w.timer.Stop()
+		}
+	}
+	return n, err
+}
+
+// streamCause replace err with the watchdog timeout if the context was canceled by the watchdog
+func streamCause(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if cause == ErrStreamIdleTimeout || cause == ErrFirstByteTimeout {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package ray
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoStreamIdleTimeout(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Set the response status code
+		w.WriteHeader(http.StatusOK)
+		// Set the response body
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("tick\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		// hang up
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	opts := NewOptions(WithURL(server.URL), WithTimeout(0), WithStreamIdleTimeout(100*time.Millisecond))
+
+	lines := 0
+	err := DoStream(opts, func(r *bufio.Reader) error {
+		for {
+			_, err := r.ReadBytes('\n')
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			lines++
+		}
+	})
+
+	// Check for errors
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if lines != 3 {
+		t.Errorf("Unexpected lines. Expected: %d, Got: %d", 3, lines)
+	}
+}
+
+func TestDoStreamFirstByteTimeout(t *testing.T) {
+	// Create a test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	// Set up the test options
+	opts := NewOptions(WithURL(server.URL), WithTimeout(0), WithRetryTimes(0), WithFirstByteTimeout(100*time.Millisecond), WithStreamIdleTimeout(time.Second))
+
+	err := DoStream(opts, func(r *bufio.Reader) error {
+		t.Errorf("Unexpected call of the stream handler")
+		return nil
+	})
+
+	// Check for errors
+	if !errors.Is(err, ErrFirstByteTimeout) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDoStreamLongLived(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("tick\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	// the stream outlives the timeout of an attempt, the idle timeout guards it
+	// and the wait for the rate limit does not count against the first byte
+	limiter := &RateLimiter{RateLimit: RateLimit{Rate: 2, Burst: 1}}
+	opts := NewOptions(WithURL(server.URL), WithTimeoutDuration(100*time.Millisecond), WithRetryTimes(0), WithRateLimiter(limiter),
+		WithFirstByteTimeout(100*time.Millisecond), WithStreamIdleTimeout(100*time.Millisecond))
+	for i := 0; i < 2; i++ {
+		lines := 0
+		err := DoStream(opts, func(r *bufio.Reader) error {
+			for {
+				_, err := r.ReadBytes('\n')
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				lines++
+			}
+		})
+		if err != nil || lines != 5 {
+			t.Errorf("Unexpected stream: %v %d", err, lines)
+		}
+	}
+}
+
+func TestDoStreamFirstByteTimeoutOnly(t *testing.T) {
+	// Create a test server, the stream hangs after the first bytes
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("tick\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	// Timeout bounds the idle time once the first byte arrived
+	opts := NewOptions(WithURL(server.URL), WithTimeoutDuration(100*time.Millisecond), WithRetryTimes(0), WithFirstByteTimeout(time.Second))
+
+	start := time.Now()
+	err := DoStream(opts, func(r *bufio.Reader) error {
+		_, err := io.ReadAll(r)
+		return err
+	})
+
+	// Check for errors
+	if !errors.Is(err, ErrStreamIdleTimeout) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Unexpected elapsed time: %v", elapsed)
+	}
+}
@@ -0,0 +1,118 @@
+package ray
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// insecureWarning print the warning of InsecureSkipVerify once per process
+var insecureWarning sync.Once
+
+// hasTLSConfig whether a tls option was set
+func (o *Options) hasTLSConfig() bool {
+	return o.TLSConfig != nil || o.RootCAFile != "" || o.RootCAs != nil || len(o.Certificates) > 0 ||
+		o.ClientCertSource != nil || o.MinTLSVersion != 0 || o.ServerName != "" || o.InsecureSkipVerify
+}
+
+// tlsConfig build the tls config from options, certificates are verified unless InsecureSkipVerify is set explicitly
+func tlsConfig(opts *Options) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if opts.TLSConfig != nil {
+		cfg = opts.TLSConfig.Clone()
+	}
+	if opts.RootCAs != nil {
+		cfg.RootCAs = opts.RootCAs
+	}
+	if opts.RootCAFile != "" {
+		pem, err := os.ReadFile(opts.RootCAFile)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "tls.rootca.read,[file]%s", opts.RootCAFile)
+		}
+		// the pool of the caller may be shared by concurrent requests
+		pool := x509.NewCertPool()
+		if cfg.RootCAs != nil {
+			pool = cfg.RootCAs.Clone()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("tls.rootca.parse, no certificate found,[file]%s", opts.RootCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(opts.Certificates) > 0 {
+		cfg.Certificates = append(cfg.Certificates, opts.Certificates...)
+	}
+	if opts.ClientCertSource != nil {
+		source := opts.ClientCertSource
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return source()
+		}
+	}
+	if opts.MinTLSVersion != 0 {
+		cfg.MinVersion = opts.MinTLSVersion
+	}
+	if opts.ServerName != "" {
+		cfg.ServerName = opts.ServerName
+	}
+	if opts.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	if cfg.InsecureSkipVerify {
+		insecureWarning.Do(func() {
+			warn("tls certificate verification is disabled by InsecureSkipVerify, do not use it in production")
+		})
+	}
+	return cfg, nil
+}
+
+// WithTLSConfig set base tls config, the other tls options are applied on a clone of it
+func WithTLSConfig(cfg *tls.Config) OptionHandle {
+	return func(opt *Options) {
+		opt.TLSConfig = cfg
+	}
+}
+
+// WithRootCAFile set root CAs from a PEM file
+func WithRootCAFile(file string) OptionHandle {
+	return func(opt *Options) {
+		opt.RootCAFile = file
+	}
+}
+
+// WithRootCAs set root CAs pool
+func WithRootCAs(pool *x509.CertPool) OptionHandle {
+	return func(opt *Options) {
+		opt.RootCAs = pool
+	}
+}
+
+// WithCertificates set client certificates for mTLS
+func WithCertificates(certs ...tls.Certificate) OptionHandle {
+	return func(opt *Options) {
+		opt.Certificates = append(opt.Certificates[:len(opt.Certificates):len(opt.Certificates)], certs...)
+	}
+}
+
+// WithMinTLSVersion set minimum tls version, eg. tls.VersionTLS12
+func WithMinTLSVersion(version uint16) OptionHandle {
+	return func(opt *Options) {
+		opt.MinTLSVersion = version
+	}
+}
+
+// WithServerName set server name used to verify the certificate and for SNI
+func WithServerName(name string) OptionHandle {
+	return func(opt *Options) {
+		opt.ServerName = name
+	}
+}
+
+// WithInsecureSkipVerify disable certificate verification, a warning is logged
+func WithInsecureSkipVerify() OptionHandle {
+	return func(opt *Options) {
+		opt.InsecureSkipVerify = true
+	}
+}
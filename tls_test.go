@@ -0,0 +1,121 @@
+package ray
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTLSTestServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Set the response status code
+		w.WriteHeader(http.StatusOK)
+		// Set the response body
+		w.Write([]byte("Hello, TLS!"))
+	}))
+}
+
+func TestTLSVerifyByDefault(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	// Call the function being tested
+	_, err := Do(NewOptions(WithURL(server.URL), WithRetryTimes(0), WithMinTLSVersion(tls.VersionTLS12)))
+
+	// Check for errors
+	if err == nil {
+		t.Errorf("Expected certificate verification error, got nil")
+	}
+}
+
+func TestTLSRootCAs(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	// Call the function being tested
+	response, err := Do(NewOptions(WithURL(server.URL), WithRootCAs(pool)))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if string(response) != "Hello, TLS!" {
+		t.Errorf("Unexpected response body. Expected: %s, Got: %s", "Hello, TLS!", string(response))
+	}
+}
+
+func TestTLSRootCAFile(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Call the function being tested
+	_, err = Do(NewOptions(WithURL(server.URL), WithRootCAFile(file)))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// invalid PEM file
+	err = os.WriteFile(file, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = Do(NewOptions(WithURL(server.URL), WithRootCAFile(file)))
+	if err == nil {
+		t.Errorf("Expected root CA parse error, got nil")
+	}
+}
+
+func TestTLSInsecureSkipVerify(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	// Call the function being tested
+	_, err := Do(NewOptions(WithURL(server.URL), WithInsecureSkipVerify()))
+
+	// Check for errors
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTLSOptionsNotShared(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the pool of the caller is not modified
+	pool := x509.NewCertPool()
+	cfg, err := tlsConfig(&Options{RootCAs: pool, RootCAFile: file})
+	if err != nil || cfg.RootCAs.Equal(pool) || !pool.Equal(x509.NewCertPool()) {
+		t.Errorf("Unexpected root CAs: %v", err)
+	}
+
+	// options copied from a shared base do not write into the same array
+	base := Options{Certificates: make([]tls.Certificate, 1, 2)}
+	o1, o2 := base, base
+	WithCertificates(tls.Certificate{Certificate: [][]byte{{1}}})(&o1)
+	WithCertificates(tls.Certificate{Certificate: [][]byte{{2}}})(&o2)
+	if o1.Certificates[1].Certificate[0][0] != 1 || o2.Certificates[1].Certificate[0][0] != 2 {
+		t.Errorf("Unexpected shared certificates")
+	}
+}
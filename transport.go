@@ -0,0 +1,140 @@
+package ray
+
+import (
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedTransports transports kept by transports, the least recently used is closed beyond
+const maxCachedTransports = 64
+
+// transports transports shared by the requests of the same proxy, tls and timeout options, so connections are kept alive
+var transports = &transportCache{lru: list.New(), entries: map[transportKey]*list.Element{}}
+
+// transportKey effective configuration of a transport
+// funcs are not comparable, they are identified by the option which set them, see WithProxyFunc and WithClientCertSource
+type transportKey struct {
+	proxy                 string
+	proxyFunc             any
+	noProxy               string
+	proxyUser             string
+	proxyPassword         string
+	tlsConfig             *tls.Config
+	rootCAFile            string
+	rootCAFileMod         int64
+	rootCAFileSize        int64
+	rootCAs               *x509.CertPool
+	certificates          string
+	clientCertSource      any
+	minTLSVersion         uint16
+	serverName            string
+	insecureSkipVerify    bool
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+}
+
+// transportCache LRU of transports
+type transportCache struct {
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[transportKey]*list.Element
+}
+
+// transportEntry element of the LRU list
+type transportEntry struct {
+	key       transportKey
+	transport *http.Transport
+}
+
+// newTransportKey key of the transport of opts, false if a func set without option can not be identified
+func newTransportKey(opts *Options) (transportKey, bool) {
+	if (opts.ProxyFunc != nil && opts.proxyFuncID == nil) || (opts.ClientCertSource != nil && opts.clientCertSourceID == nil) {
+		return transportKey{}, false
+	}
+	proxy := opts.defaults().Proxy
+	if opts.Proxy != "" {
+		proxy = opts.Proxy
+	}
+	var certificates strings.Builder
+	for _, cert := range opts.Certificates {
+		for _, der := range cert.Certificate {
+			certificates.Write(der)
+		}
+		certificates.WriteByte(0)
+	}
+	key := transportKey{
+		proxy:                 proxy,
+		noProxy:               strings.Join(opts.NoProxy, "\x00"),
+		proxyUser:             opts.ProxyUser,
+		proxyPassword:         opts.ProxyPassword,
+		tlsConfig:             opts.TLSConfig,
+		rootCAFile:            opts.RootCAFile,
+		rootCAs:               opts.RootCAs,
+		certificates:          certificates.String(),
+		minTLSVersion:         opts.MinTLSVersion,
+		serverName:            opts.ServerName,
+		insecureSkipVerify:    opts.InsecureSkipVerify,
+		dialTimeout:           opts.DialTimeout,
+		tlsHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		responseHeaderTimeout: opts.ResponseHeaderTimeout,
+	}
+	// a changed file builds a new transport
+	if opts.RootCAFile != "" {
+		if info, err := os.Stat(opts.RootCAFile); err == nil {
+			key.rootCAFileMod, key.rootCAFileSize = info.ModTime().UnixNano(), info.Size()
+		}
+	}
+	if opts.ProxyFunc != nil {
+		key.proxyFunc = opts.proxyFuncID
+	}
+	if opts.ClientCertSource != nil {
+		key.clientCertSource = opts.clientCertSourceID
+	}
+	return key, true
+}
+
+// get get the transport of opts, built on the first use, closeIdle closes the idle connections of a transport
+// which is not cached because it can not be identified
+func (c *transportCache) get(opts *Options) (*http.Transport, func(), error) {
+	key, ok := newTransportKey(opts)
+	if !ok {
+		t, err := newTransport(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return t, t.CloseIdleConnections, nil
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		t := el.Value.(*transportEntry).transport
+		c.mu.Unlock()
+		// the rotated client certificate is sent by the next handshake
+		if r, ok := key.clientCertSource.(*certReloader); ok && r.changed() {
+			t.CloseIdleConnections()
+		}
+		return t, func() {}, nil
+	}
+	defer c.mu.Unlock()
+	t, err := newTransport(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.entries[key] = c.lru.PushFront(&transportEntry{key: key, transport: t})
+	for c.lru.Len() > maxCachedTransports {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		entry := el.Value.(*transportEntry)
+		delete(c.entries, entry.key)
+		// the in-flight requests finish, only the idle connections are closed
+		entry.transport.CloseIdleConnections()
+	}
+	return t, func() {}, nil
+}
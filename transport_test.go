@@ -0,0 +1,72 @@
+package ray
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportKeepAlive(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// requests of the same transport options reuse the connection
+	for i := 0; i < 3; i++ {
+		_, err := Do(NewOptions(WithURL(server.URL), WithDialTimeout(time.Second), WithResponseHeaderTimeout(time.Second)))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Unexpected connections: %d", n)
+	}
+
+	// other options use another transport
+	_, err := Do(NewOptions(WithURL(server.URL), WithDialTimeout(2*time.Second)))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("Unexpected connections: %d", n)
+	}
+}
+
+func TestTransportKey(t *testing.T) {
+	fn := WithProxyFunc(func(req *http.Request) (*url.URL, error) { return nil, nil })
+	a, _ := newTransportKey(&Options{})
+	b, _ := newTransportKey(&Options{DialTimeout: time.Second})
+	if a == b {
+		t.Errorf("Unexpected equal keys")
+	}
+	// a func is identified by the option which set it
+	o1, o2 := Options{}, Options{}
+	fn(&o1)
+	fn(&o2)
+	k1, ok1 := newTransportKey(&o1)
+	k2, ok2 := newTransportKey(&o2)
+	if !ok1 || !ok2 || k1 != k2 {
+		t.Errorf("Unexpected keys of the same option")
+	}
+	o3 := Options{}
+	WithProxyFunc(o1.ProxyFunc)(&o3)
+	if k3, _ := newTransportKey(&o3); k3 == k1 {
+		t.Errorf("Unexpected equal keys of another option")
+	}
+	// a func set without option can not be shared
+	if _, ok := newTransportKey(&Options{ProxyFunc: o1.ProxyFunc}); ok {
+		t.Errorf("Unexpected key of an unidentified func")
+	}
+}